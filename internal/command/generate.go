@@ -18,7 +18,9 @@ import (
 	"bytes"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 
@@ -32,6 +34,8 @@ import (
 
 	"github.com/score-spec/score-aca/internal/convert"
 	"github.com/score-spec/score-aca/internal/provisioners"
+	"github.com/score-spec/score-aca/internal/provisioners/loader"
+	"github.com/score-spec/score-aca/internal/provisioners/serviceprov"
 	"github.com/score-spec/score-aca/internal/state"
 )
 
@@ -40,6 +44,14 @@ const (
 	generateCmdOverridePropertyFlag = "override-property"
 	generateCmdImageFlag            = "image"
 	generateCmdOutputFlag           = "output"
+	generateCmdOutputDirFlag        = "output-dir"
+	generateCmdStrictFlag           = "strict"
+	generateCmdLimitsPolicyFlag     = "limits-policy"
+	generateCmdEnvironmentIDFlag    = "environment-id"
+	generateCmdEnvironmentNameFlag  = "environment-name"
+	generateCmdResourceGroupFlag    = "resource-group"
+	generateCmdLogAnalyticsIDFlag   = "log-analytics-workspace-id"
+	generateCmdLogAnalyticsNameFlag = "log-analytics-workspace-name"
 )
 
 var generateCmd = &cobra.Command{
@@ -53,6 +65,11 @@ var generateCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cmd.SilenceUsage = true
 
+		outputDir, _ := cmd.Flags().GetString(generateCmdOutputDirFlag)
+		if outputDir != "" && cmd.Flags().Lookup(generateCmdOutputFlag).Changed {
+			return fmt.Errorf("cannot use --%s and --%s together", generateCmdOutputFlag, generateCmdOutputDirFlag)
+		}
+
 		sd, ok, err := state.LoadStateDirectory(".")
 		if err != nil {
 			return fmt.Errorf("failed to load existing state directory: %w", err)
@@ -108,7 +125,8 @@ var generateCmd = &cobra.Command{
 			}
 
 			// Apply image override
-			for containerName, container := range workload.Containers {
+			for _, containerName := range slices.Sorted(maps.Keys(workload.Containers)) {
+				container := workload.Containers[containerName]
 				if container.Image == "." {
 					if v, _ := cmd.Flags().GetString(generateCmdImageFlag); v != "" {
 						container.Image = v
@@ -136,7 +154,15 @@ var generateCmd = &cobra.Command{
 
 		slog.Info("Primed resources", "#workloads", len(currentState.Workloads), "#resources", len(currentState.Resources))
 
-		if currentState, err = provisioners.ProvisionResources(currentState); err != nil {
+		loadedProvisioners, err := loader.LoadProvisionersFromDirectory(sd.Path, loader.DefaultSuffix)
+		if err != nil {
+			return fmt.Errorf("failed to load provisioners: %w", err)
+		}
+
+		// the built-in provisioners come last so that they can be replaced by the provisioner files
+		loadedProvisioners = append(loadedProvisioners, serviceprov.New(currentState))
+
+		if currentState, err = provisioners.ProvisionResources(cmd.Context(), currentState, loadedProvisioners); err != nil {
 			return fmt.Errorf("failed to provision resources: %w", err)
 		}
 
@@ -146,25 +172,53 @@ var generateCmd = &cobra.Command{
 		}
 		slog.Info("Persisted state file")
 
-		out := new(bytes.Buffer)
-		for workloadName := range currentState.Workloads {
-			if manifest, err := convert.Workload(currentState, workloadName); err != nil {
+		workloadNames := slices.Sorted(maps.Keys(currentState.Workloads))
+		opts := convert.Options{}
+		opts.Strict, _ = cmd.Flags().GetBool(generateCmdStrictFlag)
+		limitsPolicy, _ := cmd.Flags().GetString(generateCmdLimitsPolicyFlag)
+		if opts.LimitsPolicy = convert.LimitsPolicy(limitsPolicy); !slices.Contains(convert.LimitsPolicies, opts.LimitsPolicy) {
+			return fmt.Errorf("--%s '%s' is invalid, expected one of %v", generateCmdLimitsPolicyFlag, limitsPolicy, convert.LimitsPolicies)
+		}
+		environmentID, _ := cmd.Flags().GetString(generateCmdEnvironmentIDFlag)
+		environmentName, _ := cmd.Flags().GetString(generateCmdEnvironmentNameFlag)
+		resourceGroup, _ := cmd.Flags().GetString(generateCmdResourceGroupFlag)
+		if opts.Environment, err = convert.NewExistingEnvironment(environmentID, environmentName, resourceGroup); err != nil {
+			return fmt.Errorf("--%s, --%s, --%s are invalid: %w", generateCmdEnvironmentIDFlag, generateCmdEnvironmentNameFlag, generateCmdResourceGroupFlag, err)
+		}
+		logAnalyticsID, _ := cmd.Flags().GetString(generateCmdLogAnalyticsIDFlag)
+		logAnalyticsName, _ := cmd.Flags().GetString(generateCmdLogAnalyticsNameFlag)
+		if opts.LogAnalytics, err = convert.NewLogAnalyticsWorkspace(logAnalyticsID, logAnalyticsName); err != nil {
+			return fmt.Errorf("--%s, --%s are invalid: %w", generateCmdLogAnalyticsIDFlag, generateCmdLogAnalyticsNameFlag, err)
+		}
+		if outputDir != "" {
+			files, err := convert.Modules(currentState, workloadNames, opts)
+			if err != nil {
 				return fmt.Errorf("failed to convert workloads: %w", err)
-			} else {
-				out.WriteString(manifest)
 			}
-			slog.Info(fmt.Sprintf("Wrote manifest to manifests buffer for workload '%s'", workloadName))
+			for _, relativePath := range slices.Sorted(maps.Keys(files)) {
+				if err := writeFileAtomically(filepath.Join(outputDir, relativePath), []byte(files[relativePath])); err != nil {
+					return err
+				}
+			}
+			slog.Info(fmt.Sprintf("Wrote %d modules to '%s'", len(files), outputDir))
+			return nil
+		}
+
+		out := new(bytes.Buffer)
+		if manifest, err := convert.Workloads(currentState, workloadNames, opts); err != nil {
+			return fmt.Errorf("failed to convert workloads: %w", err)
+		} else {
+			out.WriteString(manifest)
 		}
+		slog.Info(fmt.Sprintf("Wrote manifest to manifests buffer for workloads %s", strings.Join(workloadNames, ", ")))
 
 		v, _ := cmd.Flags().GetString(generateCmdOutputFlag)
 		if v == "" {
 			return fmt.Errorf("no output file specified")
 		} else if v == "-" {
 			_, _ = fmt.Fprint(cmd.OutOrStdout(), out.String())
-		} else if err := os.WriteFile(v+".tmp", out.Bytes(), 0644); err != nil {
-			return fmt.Errorf("failed to write output file: %w", err)
-		} else if err := os.Rename(v+".tmp", v); err != nil {
-			return fmt.Errorf("failed to complete writing output file: %w", err)
+		} else if err := writeFileAtomically(v, out.Bytes()); err != nil {
+			return err
 		} else {
 			slog.Info(fmt.Sprintf("Wrote manifests to '%s'", v))
 		}
@@ -172,6 +226,19 @@ var generateCmd = &cobra.Command{
 	},
 }
 
+// writeFileAtomically writes the content to a temporary file and moves it into place, creating the parent directories
+// as needed
+func writeFileAtomically(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	} else if err := os.WriteFile(path+".tmp", content, 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	} else if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to complete writing output file: %w", err)
+	}
+	return nil
+}
+
 func parseAndApplyOverrideFile(entry string, flagName string, spec map[string]interface{}) error {
 	if raw, err := os.ReadFile(entry); err != nil {
 		return fmt.Errorf("--%s '%s' is invalid, failed to read file: %w", flagName, entry, err)
@@ -215,8 +282,16 @@ func parseAndApplyOverrideProperty(entry string, flagName string, spec map[strin
 
 func init() {
 	generateCmd.Flags().StringP(generateCmdOutputFlag, "o", "manifest.bicep", "The output manifests file to write the manifests to")
+	generateCmd.Flags().String(generateCmdOutputDirFlag, "", "An optional directory to write a main.bicep and a module for each workload and resource to, instead of a single manifests file")
 	generateCmd.Flags().String(generateCmdOverridesFileFlag, "", "An optional file of Score overrides to merge in")
 	generateCmd.Flags().StringArray(generateCmdOverridePropertyFlag, []string{}, "An optional set of path=key overrides to set or remove")
 	generateCmd.Flags().String(generateCmdImageFlag, "", "An optional container image to use for any container with image == '.'")
+	generateCmd.Flags().Bool(generateCmdStrictFlag, false, "Fail instead of adjusting invalid values with a warning, such as cpu and memory that are not an allowed combination")
+	generateCmd.Flags().String(generateCmdLimitsPolicyFlag, string(convert.LimitsPolicyFallback), "How container resource limits are mapped onto the cpu and memory: 'fallback' uses them when no requests are set, 'max' uses the maximum of the requests and limits, 'error' rejects them")
+	generateCmd.Flags().String(generateCmdEnvironmentIDFlag, "", "An optional resource id of an existing container app environment to deploy to instead of declaring one")
+	generateCmd.Flags().String(generateCmdEnvironmentNameFlag, "", "An optional name of an existing container app environment to deploy to instead of declaring one")
+	generateCmd.Flags().String(generateCmdResourceGroupFlag, "", "The resource group of the existing environment set by --environment-name, if it differs from the one of the deployment")
+	generateCmd.Flags().String(generateCmdLogAnalyticsIDFlag, "", "An optional resource id of an existing Log Analytics workspace that receives the logs of the container app environment")
+	generateCmd.Flags().String(generateCmdLogAnalyticsNameFlag, "", "An optional name of a new Log Analytics workspace that receives the logs of the container app environment")
 	rootCmd.AddCommand(generateCmd)
 }
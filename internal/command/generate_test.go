@@ -16,8 +16,10 @@ package command
 
 import (
 	"context"
+	"flag"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -26,6 +28,8 @@ import (
 	"github.com/score-spec/score-aca/internal/state"
 )
 
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
 func changeToDir(t *testing.T, dir string) string {
 	t.Helper()
 	wd, _ := os.Getwd()
@@ -121,9 +125,11 @@ resource containerApp 'Microsoft.App/containerApps@2024-03-01' = {
   properties: {
     environmentId: containerAppEnvironment.id
     configuration: {
+      activeRevisionsMode: 'Single'
       ingress: {
         external: true
         targetPort: 8080
+        transport: 'auto'
       }
     }
     template: {
@@ -131,6 +137,10 @@ resource containerApp 'Microsoft.App/containerApps@2024-03-01' = {
         {
           name: 'main'
           image: 'stefanprodan/podinfo'
+          resources: {
+            cpu: json('0.25')
+            memory: '0.5Gi'
+          }
         }
       ]
     }
@@ -221,9 +231,11 @@ resource containerApp 'Microsoft.App/containerApps@2024-03-01' = {
   properties: {
     environmentId: containerAppEnvironment.id
     configuration: {
+      activeRevisionsMode: 'Single'
       ingress: {
         external: true
         targetPort: 9898
+        transport: 'auto'
       }
     }
     template: {
@@ -237,7 +249,7 @@ resource containerApp 'Microsoft.App/containerApps@2024-03-01' = {
           }
           probes: [
             {
-              type: 'liveness'
+              type: 'Liveness'
               initialDelaySeconds: 15
               periodSeconds: 30
               failureThreshold: 3
@@ -248,7 +260,7 @@ resource containerApp 'Microsoft.App/containerApps@2024-03-01' = {
               }
             }
             {
-              type: 'readiness'
+              type: 'Readiness'
               initialDelaySeconds: 15
               periodSeconds: 30
               failureThreshold: 3
@@ -283,3 +295,1718 @@ resource containerApp 'Microsoft.App/containerApps@2024-03-01' = {
 output containerAppFQDN string = containerApp.properties.configuration.ingress.fqdn
 `, string(raw))
 }
+
+func TestInitAndGenerate_with_template_provisioner(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(td, ".score-aca", "00-custom.provisioners.yaml"), []byte(`
+- uri: template://custom/thing
+  type: thing
+  init: |
+    symbol: {{ bicepSymbol "thing" .Id }}
+  outputs: |
+    host: {{ printf "${%s.properties.host}" .Init.symbol | quote }}
+    size: {{ .Params.size }}
+  bicep: |
+    resource {{ .Init.symbol }} 'Example.Thing@2024-01-01' = {
+      name: '{{ .SourceWorkload }}-thing'
+      location: location
+    }
+`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+        variables:
+            THING_HOST: ${resources.my-thing.host}
+            THING_SIZE: ${resources.my-thing.size}
+resources:
+    my-thing:
+        type: thing
+        params:
+            size: large
+`), 0644))
+
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{
+		"generate", "-o", "manifests.bicep", "--", "score.yaml",
+	})
+	require.NoError(t, err)
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(raw), `
+// Resource 'thing.default#example.my-thing'
+resource thing_example_my_thing 'Example.Thing@2024-01-01' = {
+  name: 'example-thing'
+  location: location
+}
+
+// Container App
+`)
+	assert.Contains(t, string(raw), `
+            {
+              name: 'THING_HOST'
+              value: '${thing_example_my_thing.properties.host}'
+            }
+            {
+              name: 'THING_SIZE'
+              value: 'large'
+            }
+`)
+
+	sd, ok, err := state.LoadStateDirectory(td)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	if assert.Len(t, sd.State.Resources, 1) {
+		resState := sd.State.Resources["thing.default#example.my-thing"]
+		assert.Equal(t, "template://custom/thing", resState.ProvisionerUri)
+		assert.Equal(t, map[string]interface{}{"host": "${thing_example_my_thing.properties.host}", "size": "large"}, resState.Outputs)
+	}
+}
+
+func TestInitAndGenerate_with_unsupported_resource(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+resources:
+    my-thing:
+        type: thing
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml"})
+	assert.EqualError(t, err, "failed to provision resources: thing.default#example.my-thing: resource is not supported by any provisioner")
+}
+
+func TestInitAndGenerate_with_postgres(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+        variables:
+            DB_URL: postgres://${resources.db.username}:${resources.db.password}@${resources.db.host}:${resources.db.port}/${resources.db.name}
+resources:
+    db:
+        type: postgres
+    reports:
+        type: postgres
+        params:
+            allowAzureServices: true
+            sku: "Standard_B1ms' ? 'x"
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep"})
+	require.NoError(t, err)
+
+	sd, ok, err := state.LoadStateDirectory(td)
+	require.NoError(t, err)
+	require.True(t, ok)
+	resState := sd.State.Resources["postgres.default#example.db"]
+	assert.Equal(t, "template://default-provisioners/postgres", resState.ProvisionerUri)
+	username, database := resState.State["username"].(string), resState.State["database"].(string)
+	assert.Regexp(t, "^user[a-z]{8}$", username)
+	assert.Regexp(t, "^db-[a-z]{8}$", database)
+
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+// Resource 'postgres.default#example.db'
+@secure()
+@description('The administrator password of the PostgreSQL server for postgres.default#example.db')
+param postgres_example_db_password string
+
+resource postgres_example_db 'Microsoft.DBforPostgreSQL/flexibleServers@2022-12-01' = {
+  name: 'pg-${uniqueString(resourceGroup().id, 'postgres.default#example.db')}'
+  location: location
+  sku: {
+    name: 'Standard_B1ms'
+    tier: 'Burstable'
+  }
+  properties: {
+    version: '16'
+    administratorLogin: '`+username+`'
+    administratorLoginPassword: postgres_example_db_password
+`)
+	assert.NotContains(t, string(raw), "resource postgres_example_db_firewall")
+	assert.Contains(t, string(raw), `
+  sku: {
+    name: 'Standard_B1ms\' ? \'x'
+    tier: 'Burstable'
+  }
+`)
+	assert.Contains(t, string(raw), `
+resource postgres_example_reports_firewall 'Microsoft.DBforPostgreSQL/flexibleServers/firewallRules@2022-12-01' = {
+  parent: postgres_example_reports
+  name: 'AllowAllAzureServices'
+  properties: {
+    startIpAddress: '0.0.0.0'
+    endIpAddress: '0.0.0.0'
+  }
+}
+
+resource postgres_example_reports_database 'Microsoft.DBforPostgreSQL/flexibleServers/databases@2022-12-01' = {
+`)
+	assert.Contains(t, string(raw), `
+resource postgres_example_db_database 'Microsoft.DBforPostgreSQL/flexibleServers/databases@2022-12-01' = {
+  parent: postgres_example_db
+  name: '`+database+`'
+`)
+	assert.Contains(t, string(raw), `
+      secrets: [
+        {
+          name: 'env-main-db-url'
+          value: 'postgres://`+username+`:${postgres_example_db_password}@${postgres_example_db.properties.fullyQualifiedDomainName}:5432/`+database+`'
+        }
+      ]
+`)
+	assert.Contains(t, string(raw), `
+            {
+              name: 'DB_URL'
+              secretRef: 'env-main-db-url'
+            }
+`)
+
+	// the generated names are stable across runs
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests2.bicep"})
+	require.NoError(t, err)
+	raw2, err := os.ReadFile(filepath.Join(td, "manifests2.bicep"))
+	require.NoError(t, err)
+	assert.Equal(t, string(raw), string(raw2))
+}
+
+func TestInitAndGenerate_with_redis(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+        variables:
+            REDIS_URL: rediss://${resources.cache.username}:${resources.cache.password}@${resources.cache.host}:${resources.cache.port}
+resources:
+    cache:
+        type: redis
+        params:
+            sku: Standard
+            capacity: 1
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep"})
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+// Resource 'redis.default#example.cache'
+resource redis_example_cache 'Microsoft.Cache/redis@2023-08-01' = {
+  name: 'redis-${uniqueString(resourceGroup().id, 'redis.default#example.cache')}'
+  location: location
+  properties: {
+    sku: {
+      name: 'Standard'
+      family: 'C'
+      capacity: 1
+    }
+    enableNonSslPort: false
+    minimumTlsVersion: '1.2'
+  }
+}
+`)
+	assert.Contains(t, string(raw), `
+      secrets: [
+        {
+          name: 'env-main-redis-url'
+          value: 'rediss://default:${listKeys(redis_example_cache.id, redis_example_cache.apiVersion).primaryKey}@${redis_example_cache.properties.hostName}:${redis_example_cache.properties.sslPort}'
+        }
+      ]
+`)
+	assert.Contains(t, string(raw), `
+            {
+              name: 'REDIS_URL'
+              secretRef: 'env-main-redis-url'
+            }
+`)
+	t.Run("invalid capacity", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(filepath.Join(td, "other.score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: other
+containers:
+    main:
+        image: stefanprodan/podinfo
+resources:
+    cache:
+        type: redis
+        params:
+            capacity: "1 }"
+`), 0644))
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "other.score.yaml", "-o", "manifests.bicep"})
+		assert.EqualError(t, err, "failed to provision resources: redis.default#other.cache: failed to provision with 'template://default-provisioners/redis': bicep template failed: failed to execute template: template: :8:46: executing \"\" at <bicepInt>: error calling bicepInt: '1 }' is not an integer")
+	})
+}
+
+func TestInitAndGenerate_with_volume(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+        volumes:
+            /data:
+                source: ${resources.data}
+            /config:
+                source: ${resources.data}
+                path: config
+                readOnly: true
+resources:
+    data:
+        type: volume
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep"})
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+// Resource 'volume.default#example.data'
+resource volume_example_data_account 'Microsoft.Storage/storageAccounts@2023-01-01' = {
+  name: 'st${uniqueString(resourceGroup().id, 'volume.default#example.data')}'
+`)
+	assert.Contains(t, string(raw), `
+resource volume_example_data 'Microsoft.App/managedEnvironments/storages@2024-03-01' = {
+  parent: containerAppEnvironment
+`)
+	assert.Contains(t, string(raw), `
+          volumeMounts: [
+            {
+              volumeName: 'main-config'
+              mountPath: '/config'
+              subPath: 'config'
+            }
+            {
+              volumeName: 'main-data'
+              mountPath: '/data'
+            }
+          ]
+        }
+      ]
+      volumes: [
+        {
+          name: 'main-config'
+          storageType: 'AzureFile'
+          storageName: '${volume_example_data_read_only.name}'
+        }
+        {
+          name: 'main-data'
+          storageType: 'AzureFile'
+          storageName: '${volume_example_data.name}'
+        }
+      ]
+`)
+
+	t.Run("existing environment in another resource group", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{
+			"generate", "score.yaml", "-o", "manifests.bicep",
+			"--environment-id", "/subscriptions/0000/resourceGroups/platform/providers/Microsoft.App/managedEnvironments/shared",
+		})
+		assert.EqualError(t, err, "failed to convert workloads: resource 'volume.default#example.data': the Bicep declares a child resource of the container app environment, which cannot be deployed to its resource group 'platform', reference the environment by its name only when it is in the resource group of the deployment")
+
+		_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "--output-dir", "out", "--environment-name", "shared", "--resource-group", "platform"})
+		assert.EqualError(t, err, "failed to convert workloads: resource 'volume.default#example.data': the Bicep declares a child resource of the container app environment, which cannot be deployed to its resource group 'platform', reference the environment by its name only when it is in the resource group of the deployment")
+	})
+
+	t.Run("existing environment in the resource group of the deployment", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep", "--environment-name", "shared"})
+		require.NoError(t, err)
+		raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), "resource volume_example_data 'Microsoft.App/managedEnvironments/storages@2024-03-01' = {\n  parent: containerAppEnvironment\n")
+	})
+	t.Run("invalid quota", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(filepath.Join(td, "other.score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: other
+containers:
+    main:
+        image: stefanprodan/podinfo
+resources:
+    data:
+        type: volume
+        params:
+            quota: 1.5
+`), 0644))
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "other.score.yaml", "-o", "manifests.bicep"})
+		assert.EqualError(t, err, "failed to provision resources: volume.default#other.data: failed to provision with 'template://default-provisioners/volume': bicep template failed: failed to execute template: template: :23:45: executing \"\" at <bicepInt>: error calling bicepInt: '1.5' is not an integer")
+	})
+}
+
+func TestInitAndGenerate_with_files(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "config.yaml"), []byte("name: ${metadata.name}\nquote: it's\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+        files:
+            /etc/app/config.yaml:
+                source: config.yaml
+                mode: "0644"
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep"})
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+      secrets: [
+        {
+          name: 'file-main-etc-app-config-yaml'
+          value: '''
+name: example
+quote: it's
+'''
+        }
+      ]
+`)
+	assert.Contains(t, string(raw), `
+          volumeMounts: [
+            {
+              volumeName: 'files-main'
+              mountPath: '/etc/app/config.yaml'
+              subPath: 'file-main-etc-app-config-yaml'
+            }
+          ]
+        }
+      ]
+      volumes: [
+        {
+          name: 'files-main'
+          storageType: 'Secret'
+          secrets: [
+            {
+              secretRef: 'file-main-etc-app-config-yaml'
+              path: 'file-main-etc-app-config-yaml'
+            }
+          ]
+        }
+      ]
+`)
+}
+
+func TestInitAndGenerate_with_multiple_workloads(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: my-api
+containers:
+    main:
+        image: stefanprodan/podinfo
+        variables:
+            REDIS_HOST: ${resources.cache.host}
+service:
+    ports:
+        web:
+            port: 8080
+resources:
+    cache:
+        type: redis
+        id: shared-cache
+`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score2.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: worker
+containers:
+    main:
+        image: busybox
+        variables:
+            REDIS_HOST: ${resources.cache.host}
+resources:
+    cache:
+        type: redis
+        id: shared-cache
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "score2.yaml", "-o", "manifests.bicep"})
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+// Parameters
+param environmentName string = 'score-aca-environment'
+param containerAppName_my_api string = 'my-api-container-app'
+param containerAppName_worker string = 'worker-container-app'
+param location string = resourceGroup().location
+`)
+	assert.Equal(t, 1, strings.Count(string(raw), "resource containerAppEnvironment "))
+	assert.Equal(t, 1, strings.Count(string(raw), "// Resource 'redis.default#shared-cache'"))
+	assert.Contains(t, string(raw), `
+// Container App 'my-api'
+resource containerApp_my_api 'Microsoft.App/containerApps@2024-03-01' = {
+  name: containerAppName_my_api
+  location: location
+  properties: {
+    environmentId: containerAppEnvironment.id
+`)
+	assert.Contains(t, string(raw), `
+// Container App 'worker'
+resource containerApp_worker 'Microsoft.App/containerApps@2024-03-01' = {
+  name: containerAppName_worker
+`)
+	assert.True(t, strings.HasSuffix(string(raw), `
+// Outputs
+output containerAppFQDN_my_api string = containerApp_my_api.properties.configuration.ingress.fqdn
+`))
+}
+
+func TestInitAndGenerate_with_output_dir(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+        variables:
+            REDIS_HOST: ${resources.cache.host}
+            REDIS_USERNAME: ${resources.cache.username}
+        volumes:
+            /data:
+                source: ${resources.data}
+service:
+    ports:
+        web:
+            port: 8080
+resources:
+    cache:
+        type: redis
+    data:
+        type: volume
+`), 0644))
+
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep", "--output-dir", "out"})
+	assert.EqualError(t, err, "cannot use --output and --output-dir together")
+
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "--output-dir", "out"})
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(filepath.Join(td, "out", "main.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+// Resource 'redis.default#example.cache'
+module redis_default_example_cache 'modules/resources/redis_default_example_cache.bicep' = {
+  name: 'redis_default_example_cache'
+  params: {
+    location: location
+  }
+}
+`)
+	assert.Contains(t, string(raw), `
+// Container App 'example'
+module containerApp 'modules/example.bicep' = {
+  name: 'example'
+  params: {
+    cache_host: redis_default_example_cache.outputs.host
+    containerAppName: containerAppName
+    data_storage_name: volume_default_example_data.outputs.storage_name
+    environmentName: containerAppEnvironment.name
+    location: location
+  }
+}
+
+// Outputs
+output containerAppFQDN string = containerApp.outputs.containerAppFQDN
+`)
+
+	raw, err = os.ReadFile(filepath.Join(td, "out", "modules", "example.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+// Parameters
+param environmentName string
+param containerAppName string = 'example-container-app'
+param location string = resourceGroup().location
+param cache_host string
+param data_storage_name string
+
+// Container App Environment
+resource containerAppEnvironment 'Microsoft.App/managedEnvironments@2024-03-01' existing = {
+  name: environmentName
+}
+`)
+	assert.Contains(t, string(raw), `
+              name: 'REDIS_HOST'
+              value: '${cache_host}'
+            }
+            {
+              name: 'REDIS_USERNAME'
+              value: 'default'
+            }
+`)
+	assert.Contains(t, string(raw), `
+          storageName: '${data_storage_name}'
+`)
+
+	raw, err = os.ReadFile(filepath.Join(td, "out", "modules", "resources", "redis_default_example_cache.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+// Outputs
+output host string = '${redis_example_cache.properties.hostName}'
+`)
+
+	raw, err = os.ReadFile(filepath.Join(td, "out", "modules", "resources", "volume_default_example_data.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+// Outputs
+output storage_name string = '${volume_example_data.name}'
+`)
+}
+
+func TestInitAndGenerate_with_output_dir_and_resource_params(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, ".score-aca", "00-custom.provisioners.yaml"), []byte(`
+- uri: template://custom/postgres-rule
+  type: postgres-rule
+  bicep: |
+    resource postgres_rule 'Microsoft.DBforPostgreSQL/flexibleServers/firewallRules@2022-12-01' = {
+      parent: postgres_example_db
+      name: 'office'
+    }
+`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+        variables:
+            DB_HOST: ${resources.db.host}
+resources:
+    db:
+        type: postgres
+`), 0644))
+
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "--output-dir", "out"})
+	require.NoError(t, err)
+	raw, err := os.ReadFile(filepath.Join(td, "out", "main.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+// Resource 'postgres.default#example.db'
+@secure()
+@description('The administrator password of the PostgreSQL server for postgres.default#example.db')
+param postgres_example_db_password string
+module postgres_default_example_db 'modules/resources/postgres_default_example_db.bicep' = {
+  name: 'postgres_default_example_db'
+  params: {
+    location: location
+    postgres_example_db_password: postgres_example_db_password
+  }
+}
+`)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+        variables:
+            DB_HOST: ${resources.db.host}
+resources:
+    db:
+        type: postgres
+    rule:
+        type: postgres-rule
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "--output-dir", "out"})
+	assert.EqualError(t, err, "failed to convert workloads: resource 'postgres-rule.default#example.rule': the Bicep uses the symbol 'postgres_example_db' of resource 'postgres.default#example.db', which is declared in another module")
+}
+
+func TestInitAndGenerate_with_service(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "backend.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: backend
+containers:
+    main:
+        image: stefanprodan/podinfo
+service:
+    ports:
+        web:
+            port: 80
+            targetPort: 9898
+`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "frontend.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: frontend
+containers:
+    main:
+        image: stefanprodan/podinfo
+        variables:
+            BACKEND_URL: http://${resources.backend.host}
+            BACKEND_FQDN: ${resources.backend.fqdn}
+resources:
+    backend:
+        type: service
+        params:
+            workload: backend
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "backend.yaml", "frontend.yaml", "-o", "manifests.bicep"})
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+          env: [
+            {
+              name: 'BACKEND_FQDN'
+              value: '${containerAppName_backend}.internal.${containerAppEnvironment.properties.defaultDomain}'
+            }
+            {
+              name: 'BACKEND_URL'
+              value: 'http://${containerAppName_backend}'
+            }
+          ]
+`)
+
+	// in modules the name parameter of the called workload is passed to the workload module of the caller
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "backend.yaml", "frontend.yaml", "--output-dir", "out"})
+	require.NoError(t, err)
+	raw, err = os.ReadFile(filepath.Join(td, "out", "main.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+module containerApp_frontend 'modules/frontend.bicep' = {
+  name: 'frontend'
+  params: {
+    containerAppName: containerAppName_frontend
+    containerAppName_backend: containerAppName_backend
+    environmentName: containerAppEnvironment.name
+    location: location
+  }
+}
+`)
+	raw, err = os.ReadFile(filepath.Join(td, "out", "modules", "frontend.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+param location string = resourceGroup().location
+param containerAppName_backend string
+`)
+	assert.Contains(t, string(raw), `
+              name: 'BACKEND_URL'
+              value: 'http://${containerAppName_backend}'
+`)
+
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "-o", "manifests.bicep", "--override-property", "resources.backend.params.workload=unknown", "frontend.yaml"})
+	assert.EqualError(t, err, "failed to provision resources: service.default#frontend.backend: failed to provision with 'builtin://service': workload 'unknown' does not exist in the project")
+}
+
+func TestInitAndGenerate_with_escaped_values(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+        args: ["--message", "it's <b>bold</b> & loud"]
+        variables:
+            CONNECTION: Server=db;Password='p&ss<>'
+            LITERAL: $${not.a.placeholder}
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep"})
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+          args: [
+            '--message'
+            'it\'s <b>bold</b> & loud'
+          ]
+          resources: {
+            cpu: json('0.25')
+            memory: '0.5Gi'
+          }
+          env: [
+            {
+              name: 'CONNECTION'
+              value: 'Server=db;Password=\'p&ss<>\''
+            }
+            {
+              name: 'LITERAL'
+              value: '\${not.a.placeholder}'
+            }
+          ]
+`)
+}
+
+// TestInitAndGenerate_golden generates the workloads in testdata/golden several times, each time in a new project, and
+// compares the manifest byte for byte with the golden file. Run the tests with -update to rewrite the golden file.
+func TestInitAndGenerate_golden(t *testing.T) {
+	goldenDir, err := filepath.Abs(filepath.Join("testdata", "golden"))
+	require.NoError(t, err)
+	scoreFiles := []string{"frontend.score.yaml", "backend.score.yaml"}
+	goldenFile := filepath.Join(goldenDir, "manifests.bicep")
+
+	var previous string
+	for i := 0; i < 5; i++ {
+		td := changeToTempDir(t)
+		for _, f := range scoreFiles {
+			raw, err := os.ReadFile(filepath.Join(goldenDir, f))
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(td, f), raw, 0644))
+		}
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+		require.NoError(t, err)
+		// generate twice so that the manifest from the persisted state is checked too
+		for j := 0; j < 2; j++ {
+			_, _, err = executeAndResetCommand(context.Background(), rootCmd, append([]string{"generate", "-o", "manifests.bicep", "--"}, scoreFiles...))
+			require.NoError(t, err)
+			raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+			require.NoError(t, err)
+			if previous != "" {
+				require.Equal(t, previous, string(raw), "generated manifest changed between runs")
+			}
+			previous = string(raw)
+		}
+	}
+
+	if *updateGolden {
+		require.NoError(t, os.WriteFile(goldenFile, []byte(previous), 0644))
+	}
+	expected, err := os.ReadFile(goldenFile)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), previous)
+}
+
+func TestInitAndGenerate_with_key_vault_secret(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, ".score-aca", "00-custom.provisioners.yaml"), []byte(`
+- uri: template://custom/api-key
+  type: api-key
+  outputs: |
+    key: https://my-vault.vault.azure.net/secrets/{{ .Id | replace "." "-" }}
+  secret_outputs: [key]
+`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+    annotations:
+        score-aca.score.dev/key-vault-identity: /subscriptions/sub/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/app
+containers:
+    main:
+        image: stefanprodan/podinfo
+        variables:
+            API_KEY: ${resources.key.key}
+            BROKEN: key=${resources.key.key}
+resources:
+    key:
+        type: api-key
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep"})
+	assert.EqualError(t, err, "failed to convert workloads: workload: example: failed to convert to Bicep: failed to generate container app: failed to create container app properties: containers: main: variables: BROKEN: the Key Vault secret 'https://my-vault.vault.azure.net/secrets/example-key' cannot be combined with other values")
+
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep", "--override-property", "containers.main.variables.BROKEN="})
+	require.NoError(t, err)
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+  identity: {
+    type: 'UserAssigned'
+    userAssignedIdentities: {
+      '/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/app': {}
+    }
+  }
+`)
+	assert.Contains(t, string(raw), `
+      secrets: [
+        {
+          name: 'env-main-api-key'
+          keyVaultUrl: 'https://my-vault.vault.azure.net/secrets/example-key'
+          identity: '/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/app'
+        }
+      ]
+`)
+	assert.Contains(t, string(raw), `
+            {
+              name: 'API_KEY'
+              secretRef: 'env-main-api-key'
+            }
+`)
+}
+
+func TestInitAndGenerate_with_ingress_annotations(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+    annotations:
+        score-aca.score.dev/ingress-external: "false"
+        score-aca.score.dev/ingress-allow-insecure: "true"
+        score-aca.score.dev/ingress-ip-allow: office=203.0.113.0/24,198.51.100.7
+containers:
+    main:
+        image: stefanprodan/podinfo
+service:
+    ports:
+        web:
+            port: 8080
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep"})
+	require.NoError(t, err)
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+      ingress: {
+        external: false
+        targetPort: 8080
+        transport: 'auto'
+        allowInsecure: true
+        ipSecurityRestrictions: [
+          {
+            name: 'office'
+            action: 'Allow'
+            ipAddressRange: '203.0.113.0/24'
+          }
+          {
+            name: 'allow-2'
+            action: 'Allow'
+            ipAddressRange: '198.51.100.7/32'
+          }
+        ]
+      }
+`)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+    annotations:
+        score-aca.score.dev/ingress-ip-deny: 10.0.0.0/33
+containers:
+    main:
+        image: stefanprodan/podinfo
+service:
+    ports:
+        web:
+            port: 8080
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep"})
+	assert.EqualError(t, err, "failed to convert workloads: workload: example: failed to convert to Bicep: failed to generate container app: failed to create container app properties: annotation 'score-aca.score.dev/ingress-ip-deny': '10.0.0.0/33' is not a valid IP address or CIDR range")
+}
+
+func TestInitAndGenerate_with_scale_annotations(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+    annotations:
+        score-aca.score.dev/min-replicas: "1"
+        score-aca.score.dev/max-replicas: "20"
+        score-aca.score.dev/scale-http-concurrency: "100"
+        score-aca.score.dev/scale-rules: |
+            - name: queue
+              type: azure-queue
+              metadata:
+                queueName: jobs
+                queueLength: 10
+              auth:
+                - triggerParameter: connection
+                  value: $${not.a.secret}
+containers:
+    main:
+        image: stefanprodan/podinfo
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep"})
+	require.NoError(t, err)
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+      secrets: [
+        {
+          name: 'scale-queue-connection'
+          value: '\${not.a.secret}'
+        }
+      ]
+`)
+	assert.Contains(t, string(raw), `
+      scale: {
+        minReplicas: 1
+        maxReplicas: 20
+        rules: [
+          {
+            name: 'http'
+            http: {
+              metadata: {
+                concurrentRequests: '100'
+              }
+            }
+          }
+          {
+            name: 'queue'
+            custom: {
+              type: 'azure-queue'
+              metadata: {
+                queueLength: '10'
+                queueName: 'jobs'
+              }
+              auth: [
+                {
+                  secretRef: 'scale-queue-connection'
+                  triggerParameter: 'connection'
+                }
+              ]
+            }
+          }
+        ]
+      }
+`)
+
+	// the scale annotations can be kept apart from the Score file in an overrides file
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "scale.yaml"), []byte(`
+metadata:
+    annotations:
+        score-aca.score.dev/min-replicas: "2"
+        score-aca.score.dev/max-replicas: "5"
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "--overrides-file", "scale.yaml", "-o", "manifests.bicep"})
+	require.NoError(t, err)
+	raw, err = os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+      scale: {
+        minReplicas: 2
+        maxReplicas: 5
+`)
+}
+
+func TestInitAndGenerate_with_resources(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+        resources:
+            requests:
+                cpu: 300m
+                memory: 512Mi
+`), 0644))
+
+	t.Run("strict", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep", "--strict"})
+		assert.EqualError(t, err, "failed to convert workloads: workload: example: failed to convert to Bicep: failed to generate container app: failed to create container app properties: containers request 0.3 vCPU and 0.5Gi in total, which is not an allowed combination of the consumption plan, the nearest one is 0.5 vCPU and 1Gi")
+	})
+
+	t.Run("rounded up", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep"})
+		require.NoError(t, err)
+		raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), `
+          resources: {
+            cpu: json('0.5')
+            memory: '1Gi'
+          }
+`)
+	})
+}
+
+func TestInitAndGenerate_with_limits_policy(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+        resources:
+            requests:
+                cpu: 250m
+            limits:
+                cpu: 500m
+                memory: 512Mi
+    sidecar:
+        image: busybox
+        resources:
+            limits:
+                cpu: 500m
+                memory: 1Gi
+`), 0644))
+
+	for _, tc := range []struct {
+		policy   string
+		expected string
+		err      string
+	}{
+		{policy: "fallback", expected: "image: 'stefanprodan/podinfo'\n          resources: {\n            cpu: json('0.25')\n            memory: '0.5Gi'"},
+		// the total of 1 vCPU and 1.5Gi is rounded up by raising the memory of the first container
+		{policy: "max", expected: "image: 'stefanprodan/podinfo'\n          resources: {\n            cpu: json('0.5')\n            memory: '1Gi'"},
+		{policy: "error", err: "failed to convert workloads: workload: example: failed to convert to Bicep: failed to generate container app: failed to create container app properties: containers: main: resources: limits: cpu: resource limits are not supported with the 'error' limits policy, set the wanted value in the requests section"},
+		{policy: "unknown", err: "--limits-policy 'unknown' is invalid, expected one of [fallback max error]"},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep", "--limits-policy", tc.policy})
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+			require.NoError(t, err)
+			assert.Contains(t, string(raw), tc.expected)
+		})
+	}
+}
+
+func TestInitAndGenerate_with_probes(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+    annotations:
+        score-aca.score.dev/probes: |
+            main:
+                liveness:
+                    timeoutSeconds: 5
+                startup:
+                    tcpSocket:
+                        port: 8080
+                    periodSeconds: 5
+                    failureThreshold: 10
+containers:
+    main:
+        image: stefanprodan/podinfo
+        livenessProbe:
+            httpGet:
+                path: /healthz
+                port: 8080
+                httpHeaders:
+                    - name: X-Probe
+                      value: liveness
+        readinessProbe:
+            exec:
+                command: ["true"]
+`), 0644))
+
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep", "--strict"})
+	assert.EqualError(t, err, "failed to convert workloads: workload: example: failed to convert to Bicep: failed to generate container app: failed to create container app properties: containers: main: readinessProbe: exec probes are not supported by Azure Container Apps, replace it with an httpGet or tcpSocket probe in the 'score-aca.score.dev/probes' annotation")
+
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep"})
+	require.NoError(t, err)
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+          probes: [
+            {
+              type: 'Liveness'
+              initialDelaySeconds: 15
+              periodSeconds: 30
+              failureThreshold: 3
+              timeoutSeconds: 5
+              httpGet: {
+                port: 8080
+                path: '/healthz'
+                httpHeaders: [
+                  {
+                    name: 'X-Probe'
+                    value: 'liveness'
+                  }
+                ]
+              }
+            }
+            {
+              type: 'Startup'
+              initialDelaySeconds: 15
+              periodSeconds: 5
+              failureThreshold: 10
+              timeoutSeconds: 1
+              tcpSocket: {
+                port: 8080
+              }
+            }
+          ]
+`)
+}
+
+func TestInitAndGenerate_with_jobs(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "cleanup.score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: cleanup
+    annotations:
+        score-aca.score.dev/job-trigger: Schedule
+        score-aca.score.dev/job-schedule: "0 3 * * *"
+        score-aca.score.dev/job-retry-limit: "2"
+containers:
+    main:
+        image: busybox
+        command: ["echo", "cleanup"]
+`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "worker.score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: worker
+    annotations:
+        score-aca.score.dev/job-trigger: Event
+        score-aca.score.dev/max-replicas: "10"
+        score-aca.score.dev/scale-rules: |
+            - name: queue
+              type: azure-queue
+              metadata:
+                queueName: jobs
+                queueLength: 1
+              auth:
+                - triggerParameter: connection
+                  value: DefaultEndpointsProtocol=https;AccountName=example
+containers:
+    main:
+        image: busybox
+`), 0644))
+	_, _, err = executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "cleanup.score.yaml", "worker.score.yaml", "-o", "manifests.bicep"})
+	require.NoError(t, err)
+	raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `
+// Container App Job 'cleanup'
+resource containerApp_cleanup 'Microsoft.App/jobs@2024-03-01' = {
+  name: containerAppName_cleanup
+  location: location
+  properties: {
+    environmentId: containerAppEnvironment.id
+    configuration: {
+      triggerType: 'Schedule'
+      replicaTimeout: 1800
+      replicaRetryLimit: 2
+      scheduleTriggerConfig: {
+        cronExpression: '0 3 * * *'
+        parallelism: 1
+        replicaCompletionCount: 1
+      }
+    }
+    template: {
+      containers: [
+`)
+	assert.Contains(t, string(raw), `
+    configuration: {
+      triggerType: 'Event'
+      replicaTimeout: 1800
+      replicaRetryLimit: 0
+      eventTriggerConfig: {
+        parallelism: 1
+        replicaCompletionCount: 1
+        scale: {
+          maxExecutions: 10
+          rules: [
+            {
+              name: 'queue'
+              type: 'azure-queue'
+              metadata: {
+                queueLength: '1'
+                queueName: 'jobs'
+              }
+              auth: [
+                {
+                  secretRef: 'scale-queue-connection'
+                  triggerParameter: 'connection'
+                }
+              ]
+            }
+          ]
+        }
+      }
+      secrets: [
+        {
+          name: 'scale-queue-connection'
+          value: 'DefaultEndpointsProtocol=https;AccountName=example'
+        }
+      ]
+    }
+    template: {
+      containers: [
+`)
+}
+
+func TestInitAndGenerate_with_existing_environment(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+
+	t.Run("environment id", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{
+			"generate", "score.yaml", "-o", "manifests.bicep",
+			"--environment-id", "/subscriptions/0000/resourceGroups/platform/providers/Microsoft.App/managedEnvironments/shared",
+		})
+		require.NoError(t, err)
+		raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), `
+// Parameters
+param environmentName string = 'shared'
+param environmentSubscriptionId string = '0000'
+param environmentResourceGroup string = 'platform'
+param containerAppName string = 'example-container-app'
+param location string = resourceGroup().location
+
+// Container App Environment
+resource containerAppEnvironment 'Microsoft.App/managedEnvironments@2024-03-01' existing = {
+  name: environmentName
+  scope: resourceGroup(environmentSubscriptionId, environmentResourceGroup)
+}
+`)
+	})
+
+	t.Run("environment name in the same resource group", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep", "--environment-name", "shared"})
+		require.NoError(t, err)
+		raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), `
+param environmentName string = 'shared'
+param containerAppName string = 'example-container-app'
+`)
+		assert.Contains(t, string(raw), `
+resource containerAppEnvironment 'Microsoft.App/managedEnvironments@2024-03-01' existing = {
+  name: environmentName
+}
+`)
+	})
+
+	t.Run("modules", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "--output-dir", "out", "--environment-name", "shared", "--resource-group", "platform"})
+		require.NoError(t, err)
+		raw, err := os.ReadFile(filepath.Join(td, "out", "main.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), `
+resource containerAppEnvironment 'Microsoft.App/managedEnvironments@2024-03-01' existing = {
+  name: environmentName
+  scope: resourceGroup(environmentResourceGroup)
+}
+`)
+		assert.Contains(t, string(raw), `
+  params: {
+    containerAppName: containerAppName
+    environmentName: containerAppEnvironment.name
+    environmentResourceGroup: environmentResourceGroup
+    location: location
+  }
+`)
+		raw, err = os.ReadFile(filepath.Join(td, "out", "modules", "example.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), `
+param environmentName string
+param environmentResourceGroup string
+`)
+		assert.Contains(t, string(raw), `
+  scope: resourceGroup(environmentResourceGroup)
+`)
+	})
+
+	t.Run("invalid flags", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep", "--resource-group", "platform"})
+		assert.EqualError(t, err, "--environment-id, --environment-name, --resource-group are invalid: an environment resource group needs an environment name")
+	})
+
+	t.Run("annotations", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(filepath.Join(td, "other.score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: other
+    annotations:
+        score-aca.score.dev/environment-name: shared
+        score-aca.score.dev/environment-resource-group: platform
+containers:
+    main:
+        image: busybox
+`), 0644))
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "other.score.yaml", "-o", "manifests.bicep"})
+		require.NoError(t, err)
+		raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), `
+param environmentName string = 'shared'
+param environmentResourceGroup string = 'platform'
+`)
+	})
+}
+
+func TestInitAndGenerate_with_log_analytics(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+
+	t.Run("new workspace", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep", "--log-analytics-workspace-name", "logs"})
+		require.NoError(t, err)
+		raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), `
+param environmentName string = 'example-environment'
+param logAnalyticsWorkspaceName string = 'logs'
+param containerAppName string = 'example-container-app'
+param location string = resourceGroup().location
+
+// Log Analytics Workspace
+resource logAnalyticsWorkspace 'Microsoft.OperationalInsights/workspaces@2022-10-01' = {
+  name: logAnalyticsWorkspaceName
+  location: location
+  properties: {
+    sku: {
+      name: 'PerGB2018'
+    }
+    retentionInDays: 30
+  }
+}
+
+// Container App Environment
+resource containerAppEnvironment 'Microsoft.App/managedEnvironments@2024-03-01' = {
+  name: environmentName
+  location: location
+  properties: {
+    appLogsConfiguration: {
+      destination: 'log-analytics'
+      logAnalyticsConfiguration: {
+        customerId: logAnalyticsWorkspace.properties.customerId
+        sharedKey: logAnalyticsWorkspace.listKeys().primarySharedKey
+      }
+    }
+  }
+}
+`)
+	})
+
+	t.Run("invalid flags", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep", "--log-analytics-workspace-name", "logs", "--log-analytics-workspace-id", "/subscriptions/0000"})
+		assert.EqualError(t, err, "--log-analytics-workspace-id, --log-analytics-workspace-name are invalid: a Log Analytics workspace id cannot be combined with a workspace name")
+	})
+
+	t.Run("environment resource", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+containers:
+    main:
+        image: stefanprodan/podinfo
+resources:
+    env:
+        type: environment
+        params:
+            log_analytics_workspace_id: /subscriptions/0000/resourceGroups/monitoring/providers/Microsoft.OperationalInsights/workspaces/logs
+`), 0644))
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "--output-dir", "out"})
+		require.NoError(t, err)
+		raw, err := os.ReadFile(filepath.Join(td, "out", "main.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), `
+param logAnalyticsWorkspaceName string = 'logs'
+param logAnalyticsSubscriptionId string = '0000'
+param logAnalyticsResourceGroup string = 'monitoring'
+`)
+		assert.Contains(t, string(raw), `
+// Log Analytics Workspace
+resource logAnalyticsWorkspace 'Microsoft.OperationalInsights/workspaces@2022-10-01' existing = {
+  name: logAnalyticsWorkspaceName
+  scope: resourceGroup(logAnalyticsSubscriptionId, logAnalyticsResourceGroup)
+}
+`)
+		assert.Contains(t, string(raw), "      destination: 'log-analytics'\n")
+	})
+}
+
+func TestInitAndGenerate_with_registries(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+    annotations:
+        score-aca.score.dev/registries: |
+            - server: ghcr.io
+              username: bot
+              password: ${metadata.name}-token
+containers:
+    main:
+        image: .
+    sidecar:
+        image: ghcr.io/acme/sidecar:1
+`), 0644))
+
+	t.Run("single file", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "--image", "myacr.azurecr.io/app:1", "-o", "manifests.bicep"})
+		require.NoError(t, err)
+		raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), "param registryIdentityName string = '${environmentName}-registry-identity'\n")
+		assert.Contains(t, string(raw), `
+// Container Registries
+resource registryIdentity 'Microsoft.ManagedIdentity/userAssignedIdentities@2023-01-31' = {
+  name: registryIdentityName
+  location: location
+}
+
+resource registry_myacr 'Microsoft.ContainerRegistry/registries@2023-07-01' existing = {
+  name: 'myacr'
+}
+
+resource registry_myacr_acrPull 'Microsoft.Authorization/roleAssignments@2022-04-01' = {
+  name: guid(registry_myacr.id, registryIdentity.id, '7f951dda-4ed3-4680-a7ca-43fe172d538d')
+  scope: registry_myacr
+  properties: {
+    roleDefinitionId: subscriptionResourceId('Microsoft.Authorization/roleDefinitions', '7f951dda-4ed3-4680-a7ca-43fe172d538d')
+    principalId: registryIdentity.properties.principalId
+    principalType: 'ServicePrincipal'
+  }
+}
+`)
+		assert.Contains(t, string(raw), `
+  identity: {
+    type: 'UserAssigned'
+    userAssignedIdentities: {
+      '${registryIdentity.id}': {}
+    }
+  }
+`)
+		assert.Contains(t, string(raw), `
+      secrets: [
+        {
+          name: 'registry-ghcr-io'
+          value: 'example-token'
+        }
+      ]
+      registries: [
+        {
+          server: 'ghcr.io'
+          username: 'bot'
+          passwordSecretRef: 'registry-ghcr-io'
+        }
+        {
+          server: 'myacr.azurecr.io'
+          identity: '${registryIdentity.id}'
+        }
+      ]
+`)
+		assert.Contains(t, string(raw), `
+  dependsOn: [
+    registry_myacr_acrPull
+  ]
+}
+`)
+	})
+
+	t.Run("modules", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "--image", "myacr.azurecr.io/app:1", "--output-dir", "out"})
+		require.NoError(t, err)
+		raw, err := os.ReadFile(filepath.Join(td, "out", "main.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), `
+module containerApp 'modules/example.bicep' = {
+  name: 'example'
+  params: {
+    containerAppName: containerAppName
+    environmentName: containerAppEnvironment.name
+    location: location
+    registryIdentityName: registryIdentity.name
+  }
+  dependsOn: [
+    registry_myacr_acrPull
+  ]
+}
+`)
+		raw, err = os.ReadFile(filepath.Join(td, "out", "modules", "example.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), `
+resource registryIdentity 'Microsoft.ManagedIdentity/userAssignedIdentities@2023-01-31' existing = {
+  name: registryIdentityName
+}
+`)
+		assert.NotContains(t, string(raw), "dependsOn")
+	})
+
+	t.Run("invalid registry", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+    annotations:
+        score-aca.score.dev/registries: "[{server: ghcr.io}]"
+containers:
+    main:
+        image: ghcr.io/acme/app:1
+`), 0644))
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml"})
+		assert.EqualError(t, err, "failed to convert workloads: workload: example: annotation 'score-aca.score.dev/registries': registries[0]: 'ghcr.io' is not an Azure Container Registry, set an identity or a username and password")
+	})
+}
+
+func TestInitAndGenerate_with_identity(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	require.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(td, ".score-aca", "00-custom.provisioners.yaml"), []byte(`
+- uri: template://custom/blob-storage
+  type: blob-storage
+  init: |
+    symbol: {{ bicepSymbol "storage" .Id }}
+  outputs: |
+    account: {{ printf "${%s.name}" .Init.symbol | quote }}
+  bicep: |
+    resource {{ .Init.symbol }} 'Microsoft.Storage/storageAccounts@2023-01-01' = {
+      name: 'data'
+      location: location
+      sku: {
+        name: 'Standard_LRS'
+      }
+      kind: 'StorageV2'
+    }
+  role_assignments: |
+    - scope: {{ .Init.symbol }}
+      role: 2a2b9908-6ea1-4ae2-8e65-a410df84e7d1
+`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "score.yaml"), []byte(`
+apiVersion: score.dev/v1b1
+metadata:
+    name: example
+    annotations:
+        score-aca.score.dev/user-assigned-identities: /subscriptions/0000/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/shared
+containers:
+    main:
+        image: nginx
+        variables:
+            AZURE_CLIENT_ID: ${resources.id.client_id}
+            STORAGE_ACCOUNT: ${resources.data.account}
+resources:
+    id:
+        type: identity
+        params:
+            name: example-identity
+    data:
+        type: blob-storage
+`), 0644))
+
+	t.Run("single file", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "-o", "manifests.bicep"})
+		require.NoError(t, err)
+		raw, err := os.ReadFile(filepath.Join(td, "manifests.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), `
+// Resource 'identity.default#example.id'
+resource identity_example_id 'Microsoft.ManagedIdentity/userAssignedIdentities@2023-01-31' = {
+  name: 'example-identity'
+  location: location
+}
+`)
+		assert.Contains(t, string(raw), `
+  identity: {
+    type: 'SystemAssigned,UserAssigned'
+    userAssignedIdentities: {
+      '/subscriptions/0000/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/shared': {}
+      '${identity_example_id.id}': {}
+    }
+  }
+`)
+		assert.Contains(t, string(raw), `
+              name: 'AZURE_CLIENT_ID'
+              value: '${identity_example_id.properties.clientId}'
+`)
+		assert.Contains(t, string(raw), `
+resource containerApp_storage_example_data_roleAssignment 'Microsoft.Authorization/roleAssignments@2022-04-01' = {
+  name: guid(storage_example_data.id, containerApp.id, '2a2b9908-6ea1-4ae2-8e65-a410df84e7d1')
+  scope: storage_example_data
+  properties: {
+    roleDefinitionId: subscriptionResourceId('Microsoft.Authorization/roleDefinitions', '2a2b9908-6ea1-4ae2-8e65-a410df84e7d1')
+    principalId: containerApp.identity.principalId
+    principalType: 'ServicePrincipal'
+  }
+}
+`)
+	})
+
+	t.Run("modules", func(t *testing.T) {
+		_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"generate", "score.yaml", "--output-dir", "out"})
+		require.NoError(t, err)
+		raw, err := os.ReadFile(filepath.Join(td, "out", "modules", "resources", "blob_storage_default_example_data.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), "output storage_example_data_name string = '${storage_example_data.name}'\n")
+		raw, err = os.ReadFile(filepath.Join(td, "out", "main.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), "    data_storage_example_data_name: blob_storage_default_example_data.outputs.storage_example_data_name\n")
+		raw, err = os.ReadFile(filepath.Join(td, "out", "modules", "example.bicep"))
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), `
+resource storage_example_data 'Microsoft.Storage/storageAccounts@2023-01-01' existing = {
+  name: data_storage_example_data_name
+}
+`)
+		assert.Contains(t, string(raw), "      '${id_id}': {}\n")
+		assert.Contains(t, string(raw), "    principalId: containerApp.identity.principalId\n")
+	})
+}
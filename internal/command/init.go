@@ -15,23 +15,30 @@
 package command
 
 import (
+	_ "embed"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 
 	"github.com/score-spec/score-go/framework"
 	scoretypes "github.com/score-spec/score-go/types"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 
+	"github.com/score-spec/score-aca/internal/provisioners/loader"
 	"github.com/score-spec/score-aca/internal/state"
 )
 
 const (
-	initCmdFileFlag = "file"
+	initCmdFileFlag                  = "file"
+	initCmdNoDefaultProvisionersFlag = "no-default-provisioners"
 )
 
+//go:embed default.provisioners.yaml
+var defaultProvisionersContent string
+
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialise the local state directory and sample score file",
@@ -64,6 +71,16 @@ var initCmd = &cobra.Command{
 			}
 		}
 
+		if v, _ := cmd.Flags().GetBool(initCmdNoDefaultProvisionersFlag); v {
+			slog.Info("Skipping creation of default provisioners file")
+		} else {
+			dst := filepath.Join(sd.Path, "zz-default"+loader.DefaultSuffix)
+			if err := os.WriteFile(dst, []byte(defaultProvisionersContent), 0644); err != nil {
+				return fmt.Errorf("failed to write default provisioners file: %w", err)
+			}
+			slog.Info("Wrote default provisioners file", "file", dst)
+		}
+
 		initCmdScoreFile, _ := cmd.Flags().GetString(initCmdFileFlag)
 		if _, err := os.Stat(initCmdScoreFile); err != nil {
 			if !errors.Is(err, os.ErrNotExist) {
@@ -100,5 +117,6 @@ var initCmd = &cobra.Command{
 
 func init() {
 	initCmd.Flags().StringP(initCmdFileFlag, "f", "score.yaml", "The score file to initialize")
+	initCmd.Flags().Bool(initCmdNoDefaultProvisionersFlag, false, "Disable writing the default provisioners file")
 	rootCmd.AddCommand(initCmd)
 }
@@ -17,6 +17,7 @@ package command
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -24,6 +25,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/score-spec/score-aca/internal/provisioners/loader"
 	"github.com/score-spec/score-aca/internal/state"
 )
 
@@ -91,3 +93,24 @@ func TestInitNominal_run_twice(t *testing.T) {
 		assert.Equal(t, map[string]interface{}{}, sd.State.SharedState)
 	}
 }
+
+func TestInit_default_provisioners(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init"})
+	assert.NoError(t, err)
+	raw, err := os.ReadFile(filepath.Join(td, ".score-aca", "zz-default.provisioners.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, defaultProvisionersContent, string(raw))
+
+	p, err := loader.LoadProvisioners(raw)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, p)
+}
+
+func TestInit_no_default_provisioners(t *testing.T) {
+	td := changeToTempDir(t)
+	_, _, err := executeAndResetCommand(context.Background(), rootCmd, []string{"init", "--no-default-provisioners"})
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(td, ".score-aca", "zz-default.provisioners.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
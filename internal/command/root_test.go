@@ -55,6 +55,7 @@ func executeAndResetCommand(ctx context.Context, cmd *cobra.Command, args []stri
 			} else {
 				_ = f.Value.Set(f.DefValue)
 			}
+			f.Changed = false
 		})
 	}
 	return nowOut.String(), nowErr.String(), err
@@ -0,0 +1,178 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	scoretypes "github.com/score-spec/score-go/types"
+)
+
+const (
+	// AnnotationPrefix is the prefix of the workload annotations that configure the generated container app
+	AnnotationPrefix = "score-aca.score.dev/"
+
+	// KeyVaultIdentityAnnotation sets the resource id of the user-assigned identity used to read Key Vault secrets, the
+	// system-assigned identity cannot be used since it only exists once the container app is created
+	KeyVaultIdentityAnnotation = AnnotationPrefix + "key-vault-identity"
+
+	// IngressPortAnnotation sets the name of the service port that the ingress forwards to, the other ports are exposed
+	// as additional port mappings
+	IngressPortAnnotation = AnnotationPrefix + "ingress-port"
+	// IngressExternalAnnotation sets whether the ingress is reachable from outside the environment, 'true' by default
+	IngressExternalAnnotation = AnnotationPrefix + "ingress-external"
+	// IngressTransportAnnotation overrides the transport of the ingress: 'auto', 'http', 'http2', or 'tcp'
+	IngressTransportAnnotation = AnnotationPrefix + "ingress-transport"
+	// IngressAllowInsecureAnnotation sets whether http connections are allowed instead of being redirected to https
+	IngressAllowInsecureAnnotation = AnnotationPrefix + "ingress-allow-insecure"
+	// IngressIPAllowAnnotation is a comma separated list of the IP ranges that are allowed to reach the ingress
+	IngressIPAllowAnnotation = AnnotationPrefix + "ingress-ip-allow"
+	// IngressIPDenyAnnotation is a comma separated list of the IP ranges that are denied from reaching the ingress
+	IngressIPDenyAnnotation = AnnotationPrefix + "ingress-ip-deny"
+
+	// MinReplicasAnnotation sets the minimum number of replicas, from 0 to 300
+	MinReplicasAnnotation = AnnotationPrefix + "min-replicas"
+	// MaxReplicasAnnotation sets the maximum number of replicas, from 1 to 300
+	MaxReplicasAnnotation = AnnotationPrefix + "max-replicas"
+	// ScaleHTTPConcurrencyAnnotation adds an http scale rule with the number of concurrent requests per replica
+	ScaleHTTPConcurrencyAnnotation = AnnotationPrefix + "scale-http-concurrency"
+	// ScaleCPUUtilizationAnnotation adds a cpu scale rule with the target utilization percentage
+	ScaleCPUUtilizationAnnotation = AnnotationPrefix + "scale-cpu-utilization"
+	// ScaleMemoryUtilizationAnnotation adds a memory scale rule with the target utilization percentage
+	ScaleMemoryUtilizationAnnotation = AnnotationPrefix + "scale-memory-utilization"
+	// ScaleRulesAnnotation holds a YAML list of custom KEDA scale rules
+	ScaleRulesAnnotation = AnnotationPrefix + "scale-rules"
+
+	// ProbesAnnotation holds a YAML map of the probes of each container by probe name, overriding their timings or
+	// replacing them by httpGet and tcpSocket probes
+	ProbesAnnotation = AnnotationPrefix + "probes"
+
+	// JobTriggerAnnotation runs the workload as a container app job with the trigger type: 'Manual', 'Schedule', or
+	// 'Event'
+	JobTriggerAnnotation = AnnotationPrefix + "job-trigger"
+	// JobScheduleAnnotation sets the cron expression of a Schedule job
+	JobScheduleAnnotation = AnnotationPrefix + "job-schedule"
+	// JobParallelismAnnotation sets the number of replicas that run in parallel in each execution of a job
+	JobParallelismAnnotation = AnnotationPrefix + "job-parallelism"
+	// JobReplicaCompletionCountAnnotation sets the number of replicas that must complete for an execution to succeed
+	JobReplicaCompletionCountAnnotation = AnnotationPrefix + "job-replica-completion-count"
+	// JobReplicaTimeoutAnnotation sets the maximum number of seconds that a replica of a job can run
+	JobReplicaTimeoutAnnotation = AnnotationPrefix + "job-replica-timeout"
+	// JobRetryLimitAnnotation sets the number of times a failed replica of a job is retried
+	JobRetryLimitAnnotation = AnnotationPrefix + "job-retry-limit"
+
+	// EnvironmentIDAnnotation sets the resource id of an existing container app environment to deploy to
+	EnvironmentIDAnnotation = AnnotationPrefix + "environment-id"
+	// EnvironmentNameAnnotation sets the name of an existing container app environment to deploy to
+	EnvironmentNameAnnotation = AnnotationPrefix + "environment-name"
+	// EnvironmentResourceGroupAnnotation sets the resource group of the existing environment when it differs from the
+	// resource group of the deployment
+	EnvironmentResourceGroupAnnotation = AnnotationPrefix + "environment-resource-group"
+
+	// SystemAssignedIdentityAnnotation enables the system-assigned identity of the container app when 'true'
+	SystemAssignedIdentityAnnotation = AnnotationPrefix + "system-assigned-identity"
+	// UserAssignedIdentitiesAnnotation is a comma separated list of the resource ids of user-assigned identities that
+	// are attached to the container app
+	UserAssignedIdentitiesAnnotation = AnnotationPrefix + "user-assigned-identities"
+
+	// RegistriesAnnotation holds a YAML list of the private registries of the images with the identity, or the
+	// username and password, used to pull from them
+	RegistriesAnnotation = AnnotationPrefix + "registries"
+
+	// WorkloadProfileAnnotation sets the name of the workload profile of the environment that runs the container app,
+	// which requires an existing environment since the generated one has no workload profiles
+	WorkloadProfileAnnotation = AnnotationPrefix + "workload-profile"
+	// WorkloadProfileTypeAnnotation sets the type of the workload profile, such as 'D4', when it differs from its name
+	WorkloadProfileTypeAnnotation = AnnotationPrefix + "workload-profile-type"
+)
+
+// workloadAnnotation returns the value of an annotation of the workload metadata
+func workloadAnnotation(spec scoretypes.Workload, name string) (string, bool) {
+	annotations, _ := spec.Metadata["annotations"].(map[string]interface{})
+	v, ok := annotations[name]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
+
+// boolAnnotation returns the value of a boolean annotation of the workload metadata, or the default value if it is
+// not set
+func boolAnnotation(spec scoretypes.Workload, name string, defaultValue bool) (bool, error) {
+	v, ok := workloadAnnotation(spec, name)
+	if !ok {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("annotation '%s': '%s' is not a boolean", name, v)
+	}
+	return b, nil
+}
+
+// ipRulesAnnotation parses an annotation holding a comma separated list of IP ranges into IP security restrictions
+// with the given action. Each range is an IP address or a CIDR range, optionally prefixed by a name like
+// 'office=203.0.113.0/24', otherwise the rules are named after the action and their position.
+func ipRulesAnnotation(spec scoretypes.Workload, name string, action string) ([]IPSecurityRestriction, error) {
+	v, ok := workloadAnnotation(spec, name)
+	if !ok {
+		return nil, nil
+	}
+	rules := make([]IPSecurityRestriction, 0)
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		ruleName, ipRange, hasName := strings.Cut(entry, "=")
+		if !hasName {
+			ruleName, ipRange = fmt.Sprintf("%s-%d", strings.ToLower(action), len(rules)+1), entry
+		}
+		ruleName, ipRange = strings.TrimSpace(ruleName), strings.TrimSpace(ipRange)
+		if ruleName == "" {
+			return nil, fmt.Errorf("annotation '%s': '%s' has an empty rule name", name, entry)
+		}
+		if ip := net.ParseIP(ipRange); ip != nil {
+			if ip.To4() != nil {
+				ipRange += "/32"
+			} else {
+				ipRange += "/128"
+			}
+		} else if _, _, err := net.ParseCIDR(ipRange); err != nil {
+			return nil, fmt.Errorf("annotation '%s': '%s' is not a valid IP address or CIDR range", name, ipRange)
+		}
+		rules = append(rules, IPSecurityRestriction{Name: ruleName, Action: action, IPAddressRange: ipRange})
+	}
+	return rules, nil
+}
+
+// intAnnotation returns the value of an integer annotation of the workload metadata and whether it is set. The value
+// must be within the given bounds.
+func intAnnotation(spec scoretypes.Workload, name string, min int, max int) (int, bool, error) {
+	v, ok := workloadAnnotation(spec, name)
+	if !ok {
+		return 0, false, nil
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return 0, false, fmt.Errorf("annotation '%s': '%s' is not an integer", name, v)
+	} else if i < min || i > max {
+		return 0, false, fmt.Errorf("annotation '%s': %d must be between %d and %d", name, i, min, max)
+	}
+	return i, true, nil
+}
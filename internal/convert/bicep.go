@@ -0,0 +1,234 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BicepSymbol joins the given parts with '_' and replaces any character that is not valid in a Bicep identifier, so
+// that names like workload names or resource uids can be used as symbolic names.
+func BicepSymbol(parts ...string) string {
+	sb := new(strings.Builder)
+	for i, r := range strings.Join(parts, "_") {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	if sb.Len() == 0 {
+		return "_"
+	}
+	return sb.String()
+}
+
+// interpolationMarker replaces the '$' of the interpolations like ${expr} found in resource outputs. This tells them
+// apart from literal text that happens to contain '${', which must be escaped when written as a Bicep string.
+const interpolationMarker = "\uE000"
+
+// markInterpolations marks each '${' in a value as the start of a Bicep interpolation
+func markInterpolations(value string) string {
+	return strings.ReplaceAll(value, "${", interpolationMarker+"{")
+}
+
+// bicepStringReplacer escapes the characters that cannot appear verbatim in a single-quoted Bicep string. A marked
+// interpolation that is never closed is escaped like literal text.
+var bicepStringReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"${", `\${`,
+	interpolationMarker+"{", `\${`,
+)
+
+// bicepString returns the value as a quoted Bicep string. Interpolations marked by markInterpolations are kept
+// verbatim while the literal text between them is escaped. Values spanning multiple lines without interpolations are
+// written as a multi-line string.
+func bicepString(value string) string {
+	if strings.Contains(value, "\n") && !strings.ContainsAny(value, "\r"+interpolationMarker) && !strings.Contains(value, "'''") && !strings.HasSuffix(value, "'") {
+		// the newline following the opening sequence is not part of the string
+		return "'''\n" + value + "'''"
+	}
+	sb := new(strings.Builder)
+	sb.WriteString("'")
+	for {
+		start := strings.Index(value, interpolationMarker+"{")
+		end := -1
+		if start >= 0 {
+			end = interpolationEnd(value, start+len(interpolationMarker))
+		}
+		if end < 0 {
+			sb.WriteString(bicepStringReplacer.Replace(value))
+			break
+		}
+		sb.WriteString(bicepStringReplacer.Replace(value[:start]))
+		sb.WriteString("$")
+		sb.WriteString(strings.ReplaceAll(value[start+len(interpolationMarker):end], interpolationMarker, "$"))
+		value = value[end:]
+	}
+	sb.WriteString("'")
+	return sb.String()
+}
+
+// BicepString returns the value as a quoted Bicep string with all of its text escaped, including any '${', so that
+// values like Score params cannot break or inject expressions when placed in the Bicep of a provisioner.
+func BicepString(value string) string {
+	return "'" + bicepStringReplacer.Replace(value) + "'"
+}
+
+// interpolationEnd returns the index following the '}' that closes the interpolation whose '{' is at the given index,
+// skipping the braces of nested objects and those inside string literals of the expression, or -1 if it is not closed
+func interpolationEnd(value string, open int) int {
+	depth, inString := 0, false
+	for i := open; i < len(value); i++ {
+		switch c := value[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '\'':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// bicepObject is a Bicep object with its properties written in order
+type bicepObject []bicepProperty
+
+// bicepProperty is a property of a Bicep object
+type bicepProperty struct {
+	Key   string
+	Value interface{}
+}
+
+// bicepArray is a Bicep array with one item per line
+type bicepArray []interface{}
+
+// bicepExpression is a Bicep expression written verbatim, like a reference to a parameter or a function call
+type bicepExpression string
+
+// bicepIdentifierRegex matches the keys of object properties that don't need quotes
+var bicepIdentifierRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// bicepWriter writes Bicep values with the indentation of the generated manifests
+type bicepWriter struct {
+	sb strings.Builder
+}
+
+// String returns the Bicep written so far
+func (w *bicepWriter) String() string {
+	return w.sb.String()
+}
+
+// WriteLine writes a line of raw Bicep
+func (w *bicepWriter) WriteLine(line string) {
+	w.sb.WriteString(line)
+	w.sb.WriteString("\n")
+}
+
+// WriteParam writes a parameter declaration with a default value
+func (w *bicepWriter) WriteParam(name string, paramType string, defaultValue interface{}) {
+	w.sb.WriteString(fmt.Sprintf("param %s %s = ", name, paramType))
+	w.writeValue(defaultValue, 0)
+	w.sb.WriteString("\n")
+}
+
+// WriteResource writes a resource declaration with the given body
+func (w *bicepWriter) WriteResource(symbol string, resourceType string, body bicepObject) {
+	w.sb.WriteString(fmt.Sprintf("resource %s %s = ", symbol, bicepString(resourceType)))
+	w.writeValue(body, 0)
+	w.sb.WriteString("\n")
+}
+
+// WriteModule writes a module declaration with the given body
+func (w *bicepWriter) WriteModule(symbol string, path string, body bicepObject) {
+	w.sb.WriteString(fmt.Sprintf("module %s %s = ", symbol, bicepString(path)))
+	w.writeValue(body, 0)
+	w.sb.WriteString("\n")
+}
+
+// WriteOutput writes an output declaration
+func (w *bicepWriter) WriteOutput(name string, outputType string, value interface{}) {
+	w.sb.WriteString(fmt.Sprintf("output %s %s = ", name, outputType))
+	w.writeValue(value, 0)
+	w.sb.WriteString("\n")
+}
+
+func (w *bicepWriter) writeValue(value interface{}, indent int) {
+	switch v := value.(type) {
+	case string:
+		w.sb.WriteString(bicepString(v))
+	case bicepExpression:
+		w.sb.WriteString(string(v))
+	case bool:
+		w.sb.WriteString(strconv.FormatBool(v))
+	case int:
+		w.sb.WriteString(strconv.Itoa(v))
+	case float64:
+		// Bicep has no literal for floating point numbers
+		w.sb.WriteString(fmt.Sprintf("json('%s')", strconv.FormatFloat(v, 'f', -1, 64)))
+	case bicepObject:
+		if len(v) == 0 {
+			w.sb.WriteString("{}")
+			return
+		}
+		w.sb.WriteString("{\n")
+		for _, p := range v {
+			w.sb.WriteString(strings.Repeat("  ", indent+1))
+			if bicepIdentifierRegex.MatchString(p.Key) {
+				w.sb.WriteString(p.Key)
+			} else {
+				w.sb.WriteString(bicepString(p.Key))
+			}
+			w.sb.WriteString(": ")
+			w.writeValue(p.Value, indent+1)
+			w.sb.WriteString("\n")
+		}
+		w.sb.WriteString(strings.Repeat("  ", indent) + "}")
+	case bicepArray:
+		if len(v) == 0 {
+			w.sb.WriteString("[]")
+			return
+		}
+		w.sb.WriteString("[\n")
+		for _, item := range v {
+			w.sb.WriteString(strings.Repeat("  ", indent+1))
+			w.writeValue(item, indent+1)
+			w.sb.WriteString("\n")
+		}
+		w.sb.WriteString(strings.Repeat("  ", indent) + "]")
+	default:
+		panic(fmt.Sprintf("unsupported Bicep value of type %T", value))
+	}
+}
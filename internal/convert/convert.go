@@ -15,13 +15,14 @@
 package convert
 
 import (
-	"bytes"
 	"fmt"
-	"html/template"
 	"log/slog"
 	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/score-spec/score-go/framework"
@@ -41,19 +42,66 @@ type ContainerAppProperties struct {
 
 // ContainerAppConfiguration represents the configuration of an Azure Container App
 type ContainerAppConfiguration struct {
-	ActiveRevisionsMode  string               `json:"activeRevisionsMode,omitempty"`
-	Ingress              *ContainerAppIngress `json:"ingress,omitempty"`
-	MaxInactiveRevisions int                  `json:"maxInactiveRevisions,omitempty"`
+	ActiveRevisionsMode  string                 `json:"activeRevisionsMode,omitempty"`
+	Ingress              *ContainerAppIngress   `json:"ingress,omitempty"`
+	MaxInactiveRevisions int                    `json:"maxInactiveRevisions,omitempty"`
+	Secrets              []ContainerAppSecret   `json:"secrets,omitempty"`
+	Registries           []ContainerAppRegistry `json:"registries,omitempty"`
+}
+
+// ContainerAppRegistry represents a private container registry of an Azure Container App. Images are pulled with a
+// managed identity, or with a username and the secret holding the password.
+type ContainerAppRegistry struct {
+	Server            string `json:"server"`
+	Identity          string `json:"identity,omitempty"`
+	Username          string `json:"username,omitempty"`
+	PasswordSecretRef string `json:"passwordSecretRef,omitempty"`
+}
+
+// ContainerAppJobConfiguration represents the trigger of an Azure Container App Job. The cron expression is only set
+// for Schedule jobs and the scale for Event jobs.
+type ContainerAppJobConfiguration struct {
+	TriggerType            string             `json:"triggerType"`
+	ReplicaTimeout         int                `json:"replicaTimeout"`
+	ReplicaRetryLimit      int                `json:"replicaRetryLimit"`
+	Parallelism            int                `json:"parallelism"`
+	ReplicaCompletionCount int                `json:"replicaCompletionCount"`
+	CronExpression         string             `json:"cronExpression,omitempty"`
+	Scale                  *ContainerAppScale `json:"scale,omitempty"`
+}
+
+// ContainerAppSecret represents a secret of an Azure Container App. The value is either set inline or read from a
+// Key Vault secret with a managed identity.
+type ContainerAppSecret struct {
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	KeyVaultURL string `json:"keyVaultUrl,omitempty"`
+	Identity    string `json:"identity,omitempty"`
+}
+
+// ContainerAppIdentity represents the managed identities of an Azure Container App
+type ContainerAppIdentity struct {
+	Type                   string   `json:"type"`
+	UserAssignedIdentities []string `json:"userAssignedIdentities,omitempty"`
 }
 
 // ContainerAppIngress represents the ingress configuration of an Azure Container App
 type ContainerAppIngress struct {
-	External               bool                    `json:"external"`
-	TargetPort             int                     `json:"targetPort"`
-	Transport              string                  `json:"transport,omitempty"`
-	AllowInsecure          bool                    `json:"allowInsecure,omitempty"`
-	Traffic                []ContainerAppTraffic   `json:"traffic,omitempty"`
-	IPSecurityRestrictions []IPSecurityRestriction `json:"ipSecurityRestrictions,omitempty"`
+	External               bool                      `json:"external"`
+	TargetPort             int                       `json:"targetPort"`
+	ExposedPort            int                       `json:"exposedPort,omitempty"`
+	Transport              string                    `json:"transport,omitempty"`
+	AllowInsecure          bool                      `json:"allowInsecure,omitempty"`
+	Traffic                []ContainerAppTraffic     `json:"traffic,omitempty"`
+	IPSecurityRestrictions []IPSecurityRestriction   `json:"ipSecurityRestrictions,omitempty"`
+	AdditionalPortMappings []ContainerAppPortMapping `json:"additionalPortMappings,omitempty"`
+}
+
+// ContainerAppPortMapping represents an additional port exposed by the ingress of an Azure Container App
+type ContainerAppPortMapping struct {
+	External    bool `json:"external"`
+	TargetPort  int  `json:"targetPort"`
+	ExposedPort int  `json:"exposedPort,omitempty"`
 }
 
 // ContainerAppTraffic represents the traffic configuration of an Azure Container App
@@ -75,23 +123,75 @@ type IPSecurityRestriction struct {
 // ContainerAppTemplate represents the template of an Azure Container App
 type ContainerAppTemplate struct {
 	Containers []ContainerAppContainer `json:"containers"`
+	Volumes    []ContainerAppVolume    `json:"volumes,omitempty"`
+	Scale      *ContainerAppScale      `json:"scale,omitempty"`
+}
+
+// ContainerAppScale represents the replica bounds and scale rules of an Azure Container App
+type ContainerAppScale struct {
+	MinReplicas *int                    `json:"minReplicas,omitempty"`
+	MaxReplicas int                     `json:"maxReplicas,omitempty"`
+	Rules       []ContainerAppScaleRule `json:"rules,omitempty"`
+}
+
+// ContainerAppScaleRule represents a scale rule of an Azure Container App. Exactly one of HTTP or Custom is set.
+type ContainerAppScaleRule struct {
+	Name   string                        `json:"name"`
+	HTTP   *ContainerAppScaleRuleTrigger `json:"http,omitempty"`
+	Custom *ContainerAppScaleRuleTrigger `json:"custom,omitempty"`
+}
+
+// ContainerAppScaleRuleTrigger represents the KEDA trigger of a scale rule. The type is only set for custom rules.
+type ContainerAppScaleRuleTrigger struct {
+	Type     string                      `json:"type,omitempty"`
+	Metadata map[string]string           `json:"metadata,omitempty"`
+	Auth     []ContainerAppScaleRuleAuth `json:"auth,omitempty"`
+}
+
+// ContainerAppScaleRuleAuth represents a secret passed to a parameter of a KEDA trigger
+type ContainerAppScaleRuleAuth struct {
+	SecretRef        string `json:"secretRef"`
+	TriggerParameter string `json:"triggerParameter"`
+}
+
+// ContainerAppVolume represents a volume in an Azure Container App
+type ContainerAppVolume struct {
+	Name        string                         `json:"name"`
+	StorageType string                         `json:"storageType"`
+	StorageName string                         `json:"storageName,omitempty"`
+	Secrets     []ContainerAppSecretVolumeItem `json:"secrets,omitempty"`
+}
+
+// ContainerAppSecretVolumeItem represents a secret exposed as a file in a volume of type 'Secret'
+type ContainerAppSecretVolumeItem struct {
+	SecretRef string `json:"secretRef"`
+	Path      string `json:"path"`
 }
 
 // ContainerAppContainer represents a container in an Azure Container App
 type ContainerAppContainer struct {
-	Name      string                `json:"name"`
-	Image     string                `json:"image"`
-	Command   []string              `json:"command,omitempty"`
-	Args      []string              `json:"args,omitempty"`
-	Env       []ContainerAppEnv     `json:"env,omitempty"`
-	Resources ContainerAppResources `json:"resources"`
-	Probes    []ContainerAppProbe   `json:"probes,omitempty"`
+	Name         string                    `json:"name"`
+	Image        string                    `json:"image"`
+	Command      []string                  `json:"command,omitempty"`
+	Args         []string                  `json:"args,omitempty"`
+	Env          []ContainerAppEnv         `json:"env,omitempty"`
+	Resources    ContainerAppResources     `json:"resources"`
+	Probes       []ContainerAppProbe       `json:"probes,omitempty"`
+	VolumeMounts []ContainerAppVolumeMount `json:"volumeMounts,omitempty"`
+}
+
+// ContainerAppVolumeMount represents a volume mount of a container in an Azure Container App
+type ContainerAppVolumeMount struct {
+	VolumeName string `json:"volumeName"`
+	MountPath  string `json:"mountPath"`
+	SubPath    string `json:"subPath,omitempty"`
 }
 
 // ContainerAppEnv represents an environment variable in an Azure Container App
 type ContainerAppEnv struct {
-	Name  string `json:"name"`
-	Value string `json:"value,omitempty"`
+	Name      string `json:"name"`
+	Value     string `json:"value,omitempty"`
+	SecretRef string `json:"secretRef,omitempty"`
 }
 
 // ContainerAppResources represents the resources of a container in an Azure Container App
@@ -102,14 +202,21 @@ type ContainerAppResources struct {
 
 // ContainerAppProbe represents a probe in an Azure Container App
 type ContainerAppProbe struct {
-	Type    string               `json:"type"`
-	HTTPGet *ContainerAppHTTPGet `json:"httpGet,omitempty"`
+	Type                string                 `json:"type"`
+	InitialDelaySeconds int                    `json:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int                    `json:"periodSeconds,omitempty"`
+	FailureThreshold    int                    `json:"failureThreshold,omitempty"`
+	SuccessThreshold    int                    `json:"successThreshold,omitempty"`
+	TimeoutSeconds      int                    `json:"timeoutSeconds,omitempty"`
+	HTTPGet             *ContainerAppHTTPGet   `json:"httpGet,omitempty"`
+	TCPSocket           *ContainerAppTCPSocket `json:"tcpSocket,omitempty"`
 }
 
 // ContainerAppHTTPGet represents an HTTP GET probe in an Azure Container App
 type ContainerAppHTTPGet struct {
 	Path        string                   `json:"path"`
 	Port        int                      `json:"port"`
+	Host        string                   `json:"host,omitempty"`
 	Scheme      string                   `json:"scheme,omitempty"`
 	HTTPHeaders []ContainerAppHTTPHeader `json:"httpHeaders,omitempty"`
 }
@@ -120,144 +227,825 @@ type ContainerAppHTTPHeader struct {
 	Value string `json:"value"`
 }
 
-// Workload converts a Score workload to a Bicep manifest
+// ContainerAppTCPSocket represents a TCP socket probe in an Azure Container App
+type ContainerAppTCPSocket struct {
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port"`
+}
+
+// ResourceBicep holds the Bicep declarations emitted by the provisioner of a resource
+type ResourceBicep struct {
+	Uid   framework.ResourceUid
+	Bicep string
+}
+
+// bicepWorkload holds a workload prepared for conversion and the symbolic names it uses in the Bicep manifest
+type bicepWorkload struct {
+	Name       string
+	Spec       scoretypes.Workload
+	ResOutputs map[string]framework.OutputLookupFunc
+
+	// AppSymbol is the symbolic name of the container app resource
+	AppSymbol string
+	// AppNameParam is the name of the parameter holding the name of the container app
+	AppNameParam string
+	// FQDNOutput is the name of the output holding the fully qualified domain name of the container app ingress
+	FQDNOutput string
+	// Registries holds the names of the Azure Container Registries that the container app pulls from with the shared
+	// registry identity
+	Registries []string
+	// RoleAssignments holds the roles granted by the provisioners of the resources to the container app
+	RoleAssignments []workloadRoleAssignment
+	// ServiceParams holds the name parameters of the container apps that the service resources of the workload resolve
+	// to, sorted by name
+	ServiceParams []string
+	// InModule is true if the container app is generated in a workload module, which references the shared registry
+	// identity instead of declaring it
+	InModule bool
+
+	Options Options
+}
+
+// Options holds the settings of a conversion that apply to all workloads
+type Options struct {
+	// Strict fails the conversion instead of adjusting invalid values with a warning, such as rounding up the cpu and
+	// memory of a container app to the nearest allowed combination
+	Strict bool
+	// LimitsPolicy selects how the resource limits of the containers are mapped, it defaults to LimitsPolicyFallback
+	LimitsPolicy LimitsPolicy
+	// Environment is an existing container app environment to deploy to, a new one is declared if it is nil
+	Environment *ExistingEnvironment
+	// LogAnalytics is the Log Analytics workspace that receives the logs of a new container app environment
+	LogAnalytics *LogAnalyticsWorkspace
+}
+
+// Workload converts a Score workload to a Bicep manifest with the default options
 func Workload(currentState *state.State, workloadName string) (string, error) {
-	resOutputs, err := currentState.GetResourceOutputForWorkload(workloadName)
+	return Workloads(currentState, []string{workloadName}, Options{})
+}
+
+// Workloads converts a set of Score workloads to a single Bicep manifest. The workloads share one container app
+// environment and each one gets its own container app. A single workload uses the short symbolic names like
+// 'containerApp', while multiple workloads get unique symbolic names derived from the workload names. Resources shared
+// by several workloads are only declared once.
+func Workloads(currentState *state.State, workloadNames []string, opts Options) (string, error) {
+	opts, err := resolveOptions(currentState, workloadNames, opts)
+	if err != nil {
+		return "", err
+	}
+	workloads, resourcesBicep, err := prepareWorkloads(currentState, workloadNames, opts, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// Convert the Score workloads to a Bicep manifest
+	bicepManifest, err := convertToBicep(workloads, resourcesBicep, opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate outputs: %w", err)
+		return "", err
+	}
+
+	return bicepManifest, nil
+}
+
+// outputLookupWrapper can replace the output lookup function of a resource that declares Bicep and is used by a workload
+type outputLookupWrapper func(workloadName string, resName string, resUid framework.ResourceUid, lookup framework.OutputLookupFunc) framework.OutputLookupFunc
+
+// prepareWorkloads prepares each workload for conversion and assigns the symbolic names of its container app. The
+// Bicep declarations of the resources used by the workloads are returned without duplicates.
+func prepareWorkloads(currentState *state.State, workloadNames []string, opts Options, wrapOutputs outputLookupWrapper) ([]bicepWorkload, []ResourceBicep, error) {
+	// the names are assigned first, so that service resources can refer to the name parameters of other workloads
+	symbols := make(map[string]string, len(workloadNames))
+	appNameParams := make(map[string]string, len(workloadNames))
+	usedSymbols := make(map[string]bool)
+	for _, workloadName := range workloadNames {
+		if len(workloadNames) == 1 {
+			appNameParams[workloadName] = "containerAppName"
+			continue
+		}
+		symbol := BicepSymbol(workloadName)
+		for i := 2; usedSymbols[symbol]; i++ {
+			symbol = BicepSymbol(workloadName, strconv.Itoa(i))
+		}
+		usedSymbols[symbol] = true
+		symbols[workloadName] = symbol
+		appNameParams[workloadName] = BicepSymbol("containerAppName", symbol)
+	}
+
+	workloads := make([]bicepWorkload, 0, len(workloadNames))
+	resourcesBicep := make([]ResourceBicep, 0)
+	seenResources := make(map[framework.ResourceUid]bool)
+	for _, workloadName := range workloadNames {
+		workload, workloadResourcesBicep, err := prepareWorkload(currentState, workloadName, appNameParams, wrapOutputs)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, rb := range workloadResourcesBicep {
+			if !seenResources[rb.Uid] {
+				seenResources[rb.Uid] = true
+				resourcesBicep = append(resourcesBicep, rb)
+			}
+		}
+
+		if len(workloadNames) == 1 {
+			workload.AppSymbol, workload.AppNameParam, workload.FQDNOutput = "containerApp", "containerAppName", "containerAppFQDN"
+		} else {
+			workload.AppSymbol = BicepSymbol("containerApp", symbols[workloadName])
+			workload.AppNameParam = appNameParams[workloadName]
+			workload.FQDNOutput = BicepSymbol("containerAppFQDN", symbols[workloadName])
+		}
+		workload.Options = opts
+		workloads = append(workloads, *workload)
 	}
-	sf := framework.BuildSubstitutionFunction(currentState.Workloads[workloadName].Spec.Metadata, resOutputs)
+	return workloads, resourcesBicep, nil
+}
 
+// prepareWorkload substitutes the placeholders in the variables and files of a workload and collects the Bicep
+// declarations of the resources it uses. The outputs of a service resource refer to the name parameter of the
+// container app of its target workload when that workload is converted too.
+func prepareWorkload(currentState *state.State, workloadName string, appNameParams map[string]string, wrapOutputs outputLookupWrapper) (*bicepWorkload, []ResourceBicep, error) {
+	resOutputs, err := currentState.GetResourceOutputForWorkload(workloadName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate outputs: %w", err)
+	}
 	spec := currentState.Workloads[workloadName].Spec
+	var serviceParams []string
+	for resName, res := range spec.Resources {
+		resUid := framework.NewResourceUid(workloadName, resName, res.Type, res.Class, res.Id)
+		resState := currentState.Resources[resUid]
+		if resState.ProvisionerUri != ServiceProvisionerUri {
+			continue
+		}
+		target, _ := resState.State[ServiceWorkloadStateKey].(string)
+		if param, ok := appNameParams[target]; ok {
+			resOutputs[resName] = resolveServiceOutputs(resOutputs[resName], target, param)
+			if !slices.Contains(serviceParams, param) {
+				serviceParams = append(serviceParams, param)
+			}
+		}
+	}
+	slices.Sort(serviceParams)
+	if wrapOutputs != nil {
+		for resName, res := range spec.Resources {
+			resUid := framework.NewResourceUid(workloadName, resName, res.Type, res.Class, res.Id)
+			if strings.TrimSpace(currentState.Resources[resUid].Extras.Bicep) != "" {
+				resOutputs[resName] = wrapOutputs(workloadName, resName, resUid, resOutputs[resName])
+			}
+		}
+	}
+	for resName, lookup := range resOutputs {
+		resOutputs[resName] = markOutputInterpolations(lookup)
+	}
+	for resName, res := range spec.Resources {
+		resUid := framework.NewResourceUid(workloadName, resName, res.Type, res.Class, res.Id)
+		if secretOutputs := currentState.Resources[resUid].Extras.SecretOutputs; len(secretOutputs) > 0 {
+			resOutputs[resName] = markSecretOutputs(resOutputs[resName], secretOutputs)
+		}
+	}
+	sf := framework.BuildSubstitutionFunction(spec.Metadata, resOutputs)
+
 	containers := maps.Clone(spec.Containers)
 	for containerName, container := range containers {
 		if container.Variables, err = convertContainerVariables(container.Variables, sf); err != nil {
-			return "", fmt.Errorf("workload: %s: container: %s: variables: %w", workloadName, containerName, err)
+			return nil, nil, fmt.Errorf("workload: %s: container: %s: variables: %w", workloadName, containerName, err)
 		}
 
 		if container.Files, err = convertContainerFiles(container.Files, currentState.Workloads[workloadName].File, sf); err != nil {
-			return "", fmt.Errorf("workload: %s: container: %s: files: %w", workloadName, containerName, err)
+			return nil, nil, fmt.Errorf("workload: %s: container: %s: files: %w", workloadName, containerName, err)
 		}
 		containers[containerName] = container
 	}
 	spec.Containers = containers
 	resources := maps.Clone(spec.Resources)
-	for resName, res := range resources {
+	resourcesBicep := make([]ResourceBicep, 0, len(resources))
+	for _, resName := range slices.Sorted(maps.Keys(resources)) {
+		res := resources[resName]
 		resUid := framework.NewResourceUid(workloadName, resName, res.Type, res.Class, res.Id)
 		resState, ok := currentState.Resources[resUid]
 		if !ok {
-			return "", fmt.Errorf("workload '%s': resource '%s' (%s) is not primed", workloadName, resName, resUid)
+			return nil, nil, fmt.Errorf("workload '%s': resource '%s' (%s) is not primed", workloadName, resName, resUid)
 		}
 		res.Params = resState.Params
 		resources[resName] = res
+		if strings.TrimSpace(resState.Extras.Bicep) != "" {
+			resourcesBicep = append(resourcesBicep, ResourceBicep{Uid: resUid, Bicep: resState.Extras.Bicep})
+		}
 	}
 	spec.Resources = resources
 
-	// Convert the Score workload to a Bicep manifest
-	bicepManifest, err := convertToBicep(spec, workloadName)
+	registries, err := sharedIdentityRegistries(spec)
 	if err != nil {
-		return "", fmt.Errorf("workload: %s: failed to convert to Bicep: %w", workloadName, err)
+		return nil, nil, fmt.Errorf("workload: %s: %w", workloadName, err)
 	}
 
-	return bicepManifest, nil
+	return &bicepWorkload{
+		Name:            workloadName,
+		Spec:            spec,
+		ResOutputs:      resOutputs,
+		Registries:      registries,
+		RoleAssignments: workloadRoleAssignments(currentState, workloadName, spec),
+		ServiceParams:   serviceParams,
+	}, resourcesBicep, nil
+}
+
+const (
+	// ServiceProvisionerUri is the uri of the built-in provisioner of service resources, whose outputs refer to the
+	// container app of their target workload
+	ServiceProvisionerUri = "builtin://service"
+	// ServiceWorkloadStateKey is the key of the resource state in which a service resource records its target workload
+	ServiceWorkloadStateKey = "workload"
+)
+
+// resolveServiceOutputs wraps the output lookup function of a service resource so that the default name of the
+// container app of the target workload is replaced by the parameter holding its name
+func resolveServiceOutputs(lookup framework.OutputLookupFunc, targetWorkload string, appNameParam string) framework.OutputLookupFunc {
+	return func(keys ...string) (interface{}, error) {
+		v, err := lookup(keys...)
+		if s, ok := v.(string); ok && err == nil {
+			return strings.ReplaceAll(s, DefaultContainerAppName(targetWorkload), "${"+appNameParam+"}"), nil
+		}
+		return v, err
+	}
+}
+
+// DefaultContainerAppName returns the default value of the parameter holding the name of the container app of a
+// workload
+func DefaultContainerAppName(workloadName string) string {
+	return workloadName + "-container-app"
+}
+
+// markOutputInterpolations wraps the output lookup function of a resource so that the Bicep interpolations in its
+// string outputs are kept when the values are written as Bicep strings
+func markOutputInterpolations(lookup framework.OutputLookupFunc) framework.OutputLookupFunc {
+	return func(keys ...string) (interface{}, error) {
+		v, err := lookup(keys...)
+		if s, ok := v.(string); ok && err == nil {
+			return markInterpolations(s), nil
+		}
+		return v, err
+	}
+}
+
+const (
+	// secretStartMarker and secretEndMarker surround the values of secret outputs once they are substituted, so that
+	// the variables using them can be converted to Container App secrets
+	secretStartMarker = "\uE001"
+	secretEndMarker   = "\uE002"
+)
+
+var (
+	secretMarkersRegex = regexp.MustCompile(secretStartMarker + `([^` + secretEndMarker + `]*)` + secretEndMarker)
+	// keyVaultSecretRegex matches the url of a Key Vault secret in the public or a sovereign cloud, with or without a
+	// version
+	keyVaultSecretRegex = regexp.MustCompile(`(?i)^https://[a-z0-9-]+\.vault\.(azure\.net|azure\.cn|usgovcloudapi\.net)/secrets/[a-z0-9-]+(/[a-z0-9-]*)?$`)
+	// keyVaultHostRegex matches a url on the host of a Key Vault, to reject the ones that are not a valid secret url
+	keyVaultHostRegex = regexp.MustCompile(`(?i)^https?://[^/]*\.vault\.(azure\.net|azure\.cn|usgovcloudapi\.net)([:/]|$)`)
+)
+
+// markSecretOutputs wraps the output lookup function of a resource so that the values of its secret outputs are marked
+func markSecretOutputs(lookup framework.OutputLookupFunc, secretOutputs []string) framework.OutputLookupFunc {
+	return func(keys ...string) (interface{}, error) {
+		v, err := lookup(keys...)
+		if err == nil && slices.Contains(secretOutputs, strings.Join(keys, ".")) {
+			return secretStartMarker + fmt.Sprint(v) + secretEndMarker, nil
+		}
+		return v, err
+	}
+}
+
+// unmarkSecrets removes the secret markers from a value and returns true if the value contained any secret
+func unmarkSecrets(value string) (string, bool) {
+	if !strings.Contains(value, secretStartMarker) {
+		return value, false
+	}
+	return secretMarkersRegex.ReplaceAllString(value, "$1"), true
 }
 
-// convertToBicep converts a Score workload to a Bicep manifest
-func convertToBicep(spec scoretypes.Workload, workloadName string) (string, error) {
+// convertSecretVariable converts a variable that uses secret outputs to a Container App secret. A variable that is
+// exactly the url of a Key Vault secret references that secret with the given user-assigned identity, any other value
+// is set inline. A secret output on the host of a Key Vault that is not a valid secret url is rejected, rather than
+// setting the url itself as the secret value.
+func convertSecretVariable(secretName string, value string, keyVaultIdentity string) (ContainerAppSecret, error) {
+	unmarked, _ := unmarkSecrets(value)
+	matches := secretMarkersRegex.FindAllStringSubmatch(value, -1)
+	for _, m := range matches {
+		if keyVaultSecretRegex.MatchString(m[1]) {
+			if len(matches) > 1 || m[0] != value {
+				return ContainerAppSecret{}, fmt.Errorf("the Key Vault secret '%s' cannot be combined with other values", m[1])
+			}
+			if keyVaultIdentity == "" || strings.EqualFold(keyVaultIdentity, "system") {
+				return ContainerAppSecret{}, fmt.Errorf("the Key Vault secret '%s' must be read with a user-assigned identity that has the Key Vault Secrets User role, set its resource id with the annotation '%s'", m[1], KeyVaultIdentityAnnotation)
+			}
+			return ContainerAppSecret{Name: secretName, KeyVaultURL: m[1], Identity: keyVaultIdentity}, nil
+		} else if keyVaultHostRegex.MatchString(m[1]) {
+			return ContainerAppSecret{}, fmt.Errorf("'%s' is not a valid Key Vault secret url, expected https://<vault>.vault.azure.net/secrets/<name>[/<version>]", m[1])
+		}
+	}
+	return ContainerAppSecret{Name: secretName, Value: unmarked}, nil
+}
+
+// convertToBicep converts prepared Score workloads to a Bicep manifest, the container app environment is declared
+// unless the options reference an existing one
+func convertToBicep(workloads []bicepWorkload, resourcesBicep []ResourceBicep, opts Options) (string, error) {
 	// Create the Bicep manifest
 	bicepContent := generateBicepHeader()
 
 	// Add parameters
-	params, err := generateBicepParameters(workloadName)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate Bicep parameters: %w", err)
-	}
-	bicepContent += params
+	bicepContent += generateBicepParameters(environmentName(workloads), workloads, opts)
 
 	// Add container app environment
-	bicepContent += generateContainerAppEnvironment()
+	bicepContent += generateContainerAppEnvironment(opts)
+	if err := checkEnvironmentResources(opts.Environment, resourcesBicep); err != nil {
+		return "", err
+	}
 
-	// Add container app
-	containerApp, err := generateContainerApp(spec, workloadName)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate container app: %w", err)
+	// Add provisioned resources
+	bicepContent += generateResources(resourcesBicep)
+
+	// Add the identity pulling from the container registries
+	bicepContent += generateRegistries(projectRegistries(workloads))
+
+	// Add container apps
+	for _, workload := range workloads {
+		containerApp, err := generateContainerApp(workload, len(workloads) > 1)
+		if err != nil {
+			return "", fmt.Errorf("workload: %s: failed to convert to Bicep: failed to generate container app: %w", workload.Name, err)
+		}
+		bicepContent += containerApp
 	}
-	bicepContent += containerApp
 
 	// Add outputs
-	bicepContent += bicepOutputs
+	bicepContent += generateBicepOutputs(workloads)
 
 	return bicepContent, nil
 }
 
+// environmentName returns the default name of the container app environment. A single workload names the environment
+// after itself while multiple workloads use a name shared by the project.
+func environmentName(workloads []bicepWorkload) string {
+	if len(workloads) == 1 {
+		return workloads[0].Name + "-environment"
+	}
+	return "score-aca-environment"
+}
+
 // generateBicepHeader generates the header of the Bicep manifest
 func generateBicepHeader() string {
 	return bicepHeader
 }
 
-// generateBicepParameters generates the parameters section of the Bicep manifest
-func generateBicepParameters(workloadName string) (string, error) {
-
-	t, err := template.New("bicepParameters").Parse(bicepParameters)
-	if err != nil {
-		return "", err
+// generateBicepParameters generates the parameters section of the Bicep manifest. An existing environment sets the
+// default name of the environment and its scope.
+func generateBicepParameters(environmentName string, workloads []bicepWorkload, opts Options) string {
+	w := new(bicepWriter)
+	w.WriteLine("")
+	w.WriteLine("// Parameters")
+	if opts.Environment != nil {
+		environmentName = opts.Environment.Name
 	}
-	// Create a buffer to hold the generated parameters
-	var buf bytes.Buffer
-
-	// Execute the template with the workload name
-	if err := t.Execute(&buf, map[string]string{"WorkloadName": workloadName}); err != nil {
-		return "", err
+	w.WriteParam("environmentName", "string", environmentName)
+	writeEnvironmentScopeParams(w, opts.Environment, true)
+	writeLogAnalyticsParams(w, opts.LogAnalytics)
+	if len(projectRegistries(workloads)) > 0 {
+		w.WriteParam("registryIdentityName", "string", bicepExpression("'${environmentName}-registry-identity'"))
+	}
+	for _, workload := range workloads {
+		w.WriteParam(workload.AppNameParam, "string", DefaultContainerAppName(workload.Name))
 	}
-	// Return the generated parameters as a string
-	return buf.String(), nil
+	w.WriteParam("location", "string", bicepExpression("resourceGroup().location"))
+	w.WriteLine("")
+	return w.String()
 }
 
-// generateContainerAppEnvironment generates the container app environment section of the Bicep manifest
-func generateContainerAppEnvironment() string {
+// generateContainerAppEnvironment generates the container app environment section of the Bicep manifest, which
+// references the existing environment if one is given, or sends the logs to the Log Analytics workspace if one is given
+func generateContainerAppEnvironment(opts Options) string {
+	if opts.Environment != nil {
+		return generateExistingContainerAppEnvironment(opts.Environment)
+	} else if opts.LogAnalytics != nil {
+		return generateLogAnalyticsWorkspace(opts.LogAnalytics)
+	}
 	return bicepContainerAppEnvironment
 }
 
+// generateResources generates the section of the Bicep manifest holding the declarations of provisioned resources
+func generateResources(resourcesBicep []ResourceBicep) string {
+	var sb strings.Builder
+	for _, rb := range resourcesBicep {
+		sb.WriteString(fmt.Sprintf("\n// Resource '%s'\n", rb.Uid))
+		sb.WriteString(strings.TrimSpace(rb.Bicep))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
 // generateContainerApp generates the container app section of the Bicep manifest
-func generateContainerApp(spec scoretypes.Workload, workloadName string) (string, error) {
+func generateContainerApp(workload bicepWorkload, multipleWorkloads bool) (string, error) {
 	// Create the container app properties
-	properties, err := createContainerAppProperties(spec)
+	properties, err := createContainerAppProperties(workload.Spec, workload.ResOutputs, workload.Options)
 	if err != nil {
 		return "", fmt.Errorf("failed to create container app properties: %w", err)
 	}
+	job, err := convertJob(workload.Spec, properties)
+	if err != nil {
+		return "", fmt.Errorf("failed to create job configuration: %w", err)
+	}
+	requestedIdentity, err := convertIdentity(workload)
+	if err != nil {
+		return "", fmt.Errorf("failed to create identity: %w", err)
+	}
+
+	configuration := bicepObject{}
+	if job != nil {
+		configuration = generateJobConfiguration(*job)
+	} else if properties.Configuration.ActiveRevisionsMode != "" {
+		configuration = append(configuration, bicepProperty{"activeRevisionsMode", properties.Configuration.ActiveRevisionsMode})
+	}
+	if ingress := properties.Configuration.Ingress; ingress != nil {
+		out := bicepObject{{"external", ingress.External}}
+		if ingress.TargetPort != 0 {
+			out = append(out, bicepProperty{"targetPort", ingress.TargetPort})
+		}
+		if ingress.ExposedPort != 0 {
+			out = append(out, bicepProperty{"exposedPort", ingress.ExposedPort})
+		}
+		if ingress.Transport != "" {
+			out = append(out, bicepProperty{"transport", ingress.Transport})
+		}
+		if ingress.AllowInsecure {
+			out = append(out, bicepProperty{"allowInsecure", true})
+		}
+		if len(ingress.IPSecurityRestrictions) > 0 {
+			rules := bicepArray{}
+			for _, r := range ingress.IPSecurityRestrictions {
+				rule := bicepObject{{"name", r.Name}, {"action", r.Action}, {"ipAddressRange", r.IPAddressRange}}
+				if r.Description != "" {
+					rule = append(rule, bicepProperty{"description", r.Description})
+				}
+				rules = append(rules, rule)
+			}
+			out = append(out, bicepProperty{"ipSecurityRestrictions", rules})
+		}
+		if len(ingress.AdditionalPortMappings) > 0 {
+			mappings := bicepArray{}
+			for _, m := range ingress.AdditionalPortMappings {
+				mapping := bicepObject{{"external", m.External}, {"targetPort", m.TargetPort}}
+				if m.ExposedPort != 0 {
+					mapping = append(mapping, bicepProperty{"exposedPort", m.ExposedPort})
+				}
+				mappings = append(mappings, mapping)
+			}
+			out = append(out, bicepProperty{"additionalPortMappings", mappings})
+		}
+		configuration = append(configuration, bicepProperty{"ingress", out})
+	}
+	if len(properties.Configuration.Secrets) > 0 {
+		secrets := bicepArray{}
+		for _, secret := range properties.Configuration.Secrets {
+			if secret.KeyVaultURL != "" {
+				secrets = append(secrets, bicepObject{{"name", secret.Name}, {"keyVaultUrl", secret.KeyVaultURL}, {"identity", secret.Identity}})
+			} else {
+				secrets = append(secrets, bicepObject{{"name", secret.Name}, {"value", secret.Value}})
+			}
+		}
+		configuration = append(configuration, bicepProperty{"secrets", secrets})
+	}
+	if len(properties.Configuration.Registries) > 0 {
+		registries := bicepArray{}
+		for _, registry := range properties.Configuration.Registries {
+			r := bicepObject{{"server", registry.Server}}
+			if registry.Identity != "" {
+				r = append(r, bicepProperty{"identity", registry.Identity})
+			} else {
+				r = append(r, bicepProperty{"username", registry.Username}, bicepProperty{"passwordSecretRef", registry.PasswordSecretRef})
+			}
+			registries = append(registries, r)
+		}
+		configuration = append(configuration, bicepProperty{"registries", registries})
+	}
 
-	// Convert properties to YAML for debugging
-	// propertiesYAML, _ := yaml.Marshal(properties)
-	// slog.Debug("Generated container app properties", slog.Any("properties", string(propertiesYAML)))
+	containers := bicepArray{}
+	for _, container := range properties.Template.Containers {
+		containers = append(containers, generateContainer(container))
+	}
+	template := bicepObject{{"containers", containers}}
+	if scale := properties.Template.Scale; scale != nil {
+		template = append(template, bicepProperty{"scale", generateScale(*scale)})
+	}
+	if len(properties.Template.Volumes) > 0 {
+		volumes := bicepArray{}
+		for _, volume := range properties.Template.Volumes {
+			v := bicepObject{{"name", volume.Name}, {"storageType", volume.StorageType}}
+			if volume.StorageName != "" {
+				v = append(v, bicepProperty{"storageName", volume.StorageName})
+			}
+			if len(volume.Secrets) > 0 {
+				items := bicepArray{}
+				for _, item := range volume.Secrets {
+					items = append(items, bicepObject{{"secretRef", item.SecretRef}, {"path", item.Path}})
+				}
+				v = append(v, bicepProperty{"secrets", items})
+			}
+			volumes = append(volumes, v)
+		}
+		template = append(template, bicepProperty{"volumes", volumes})
+	}
 
-	t, err := template.New("bicepContainerApp").Parse(bicepContainerApp)
+	resourceKind, resourceType := "Container App", "Microsoft.App/containerApps@2024-03-01"
+	if job != nil {
+		resourceKind, resourceType = "Container App Job", "Microsoft.App/jobs@2024-03-01"
+	}
+	w := new(bicepWriter)
+	w.WriteLine("")
+	if multipleWorkloads {
+		w.WriteLine(fmt.Sprintf("// %s '%s'", resourceKind, workload.Name))
+	} else {
+		w.WriteLine("// " + resourceKind)
+	}
+	body := bicepObject{
+		{"name", bicepExpression(workload.AppNameParam)},
+		{"location", bicepExpression("location")},
+	}
+	if identity := containerAppIdentity(requestedIdentity, properties); identity != nil {
+		out := bicepObject{{"type", identity.Type}}
+		if len(identity.UserAssignedIdentities) > 0 {
+			userAssigned := bicepObject{}
+			for _, id := range identity.UserAssignedIdentities {
+				userAssigned = append(userAssigned, bicepProperty{id, bicepObject{}})
+			}
+			out = append(out, bicepProperty{"userAssignedIdentities", userAssigned})
+		}
+		body = append(body, bicepProperty{"identity", out})
+	}
+	propertiesBody := bicepObject{{"environmentId", bicepExpression("containerAppEnvironment.id")}}
+	if properties.WorkloadProfileName != "" {
+		propertiesBody = append(propertiesBody, bicepProperty{"workloadProfileName", properties.WorkloadProfileName})
+	}
+	propertiesBody = append(propertiesBody, bicepProperty{"configuration", configuration}, bicepProperty{"template", template})
+	body = append(body, bicepProperty{"properties", propertiesBody})
+	if dependsOn := registryDependencies(workload); len(dependsOn) > 0 && !workload.InModule {
+		body = append(body, bicepProperty{"dependsOn", dependsOn})
+	}
+	w.WriteResource(workload.AppSymbol, resourceType, body)
+	w.sb.WriteString(generateRoleAssignments(workload))
+	return w.String(), nil
+}
+
+// containerAppIdentity returns the requested managed identities, which may be nil, together with the ones that the
+// container app needs to read its Key Vault secrets and pull its images, or nil if it needs none
+func containerAppIdentity(requested *ContainerAppIdentity, properties *ContainerAppProperties) *ContainerAppIdentity {
+	systemAssigned := false
+	userAssigned := make([]string, 0)
+	if requested != nil {
+		systemAssigned = strings.Contains(requested.Type, "SystemAssigned")
+		userAssigned = append(userAssigned, requested.UserAssignedIdentities...)
+	}
+	addIdentity := func(identity string) {
+		if strings.EqualFold(identity, "system") {
+			systemAssigned = true
+		} else if !slices.Contains(userAssigned, identity) {
+			userAssigned = append(userAssigned, identity)
+		}
+	}
+	for _, secret := range properties.Configuration.Secrets {
+		if secret.KeyVaultURL != "" {
+			addIdentity(secret.Identity)
+		}
+	}
+	for _, registry := range properties.Configuration.Registries {
+		if registry.Identity != "" {
+			addIdentity(registry.Identity)
+		}
+	}
+	return newContainerAppIdentity(systemAssigned, userAssigned)
+}
+
+// generateContainer generates a container of the container app
+func generateContainer(container ContainerAppContainer) bicepObject {
+	out := bicepObject{{"name", container.Name}, {"image", container.Image}}
+	if len(container.Command) > 0 {
+		out = append(out, bicepProperty{"command", stringsArray(container.Command)})
+	}
+	if len(container.Args) > 0 {
+		out = append(out, bicepProperty{"args", stringsArray(container.Args)})
+	}
+	out = append(out, bicepProperty{"resources", bicepObject{
+		{"cpu", container.Resources.CPU},
+		{"memory", container.Resources.Memory},
+	}})
+	if len(container.Probes) > 0 {
+		probes := bicepArray{}
+		for _, probe := range container.Probes {
+			probes = append(probes, generateProbe(probe))
+		}
+		out = append(out, bicepProperty{"probes", probes})
+	}
+	if len(container.Env) > 0 {
+		env := bicepArray{}
+		for _, e := range container.Env {
+			if e.SecretRef != "" {
+				env = append(env, bicepObject{{"name", e.Name}, {"secretRef", e.SecretRef}})
+			} else {
+				env = append(env, bicepObject{{"name", e.Name}, {"value", e.Value}})
+			}
+		}
+		out = append(out, bicepProperty{"env", env})
+	}
+	if len(container.VolumeMounts) > 0 {
+		mounts := bicepArray{}
+		for _, mount := range container.VolumeMounts {
+			m := bicepObject{{"volumeName", mount.VolumeName}, {"mountPath", mount.MountPath}}
+			if mount.SubPath != "" {
+				m = append(m, bicepProperty{"subPath", mount.SubPath})
+			}
+			mounts = append(mounts, m)
+		}
+		out = append(out, bicepProperty{"volumeMounts", mounts})
+	}
+	return out
+}
+
+// generateProbe generates a probe of a container
+func generateProbe(probe ContainerAppProbe) bicepObject {
+	// the initial delay is always set since 0 is a valid value
+	out := bicepObject{{"type", probe.Type}, {"initialDelaySeconds", probe.InitialDelaySeconds}}
+	for _, p := range []bicepProperty{
+		{"periodSeconds", probe.PeriodSeconds},
+		{"failureThreshold", probe.FailureThreshold},
+		{"successThreshold", probe.SuccessThreshold},
+		{"timeoutSeconds", probe.TimeoutSeconds},
+	} {
+		if p.Value.(int) != 0 {
+			out = append(out, p)
+		}
+	}
+	if probe.HTTPGet != nil {
+		httpGet := bicepObject{}
+		if probe.HTTPGet.Port != 0 {
+			httpGet = append(httpGet, bicepProperty{"port", probe.HTTPGet.Port})
+		}
+		if probe.HTTPGet.Path != "" {
+			httpGet = append(httpGet, bicepProperty{"path", probe.HTTPGet.Path})
+		}
+		if probe.HTTPGet.Host != "" {
+			httpGet = append(httpGet, bicepProperty{"host", probe.HTTPGet.Host})
+		}
+		if probe.HTTPGet.Scheme != "" {
+			httpGet = append(httpGet, bicepProperty{"scheme", probe.HTTPGet.Scheme})
+		}
+		if len(probe.HTTPGet.HTTPHeaders) > 0 {
+			headers := bicepArray{}
+			for _, h := range probe.HTTPGet.HTTPHeaders {
+				headers = append(headers, bicepObject{{"name", h.Name}, {"value", h.Value}})
+			}
+			httpGet = append(httpGet, bicepProperty{"httpHeaders", headers})
+		}
+		out = append(out, bicepProperty{"httpGet", httpGet})
+	}
+	if probe.TCPSocket != nil {
+		tcpSocket := bicepObject{{"port", probe.TCPSocket.Port}}
+		if probe.TCPSocket.Host != "" {
+			tcpSocket = append(tcpSocket, bicepProperty{"host", probe.TCPSocket.Host})
+		}
+		out = append(out, bicepProperty{"tcpSocket", tcpSocket})
+	}
+	return out
+}
+
+// stringsArray converts a list of strings to a Bicep array
+func stringsArray(values []string) bicepArray {
+	out := make(bicepArray, 0, len(values))
+	for _, v := range values {
+		out = append(out, v)
+	}
+	return out
+}
+
+// generateBicepOutputs generates the outputs section of the Bicep manifest with the fully qualified domain name of
+// each container app that has an ingress
+func generateBicepOutputs(workloads []bicepWorkload) string {
+	var sb strings.Builder
+	for _, workload := range workloads {
+		if workload.Spec.Service != nil && len(workload.Spec.Service.Ports) > 0 {
+			sb.WriteString(fmt.Sprintf("output %s string = %s.properties.configuration.ingress.fqdn\n", workload.FQDNOutput, workload.AppSymbol))
+		}
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return "\n// Outputs\n" + sb.String()
+}
+
+// IngressPortName returns the name of the service port that the ingress of the workload forwards to, or an empty
+// string if the workload has no service ports. This is the port named by the ingress port annotation, or else the port
+// with the lowest name, so that the choice does not depend on the order of the ports.
+func IngressPortName(spec scoretypes.Workload) (string, error) {
+	if spec.Service == nil || len(spec.Service.Ports) == 0 {
+		return "", nil
+	}
+	portNames := slices.Sorted(maps.Keys(spec.Service.Ports))
+	if v, ok := workloadAnnotation(spec, IngressPortAnnotation); ok {
+		if _, ok := spec.Service.Ports[v]; !ok {
+			return "", fmt.Errorf("annotation '%s': '%s' is not a service port, expected one of %s", IngressPortAnnotation, v, strings.Join(portNames, ", "))
+		}
+		return v, nil
+	}
+	return portNames[0], nil
+}
+
+// ConvertIngress converts the service ports of a workload to the ingress of an Azure Container App, or returns nil if
+// the workload has no service ports. The port returned by IngressPortName is the primary port and the other ports
+// become additional port mappings. A port with the TCP protocol uses the 'tcp' transport, otherwise the ingress uses
+// http. An error is returned when two ports are exposed on the same port within the environment. The visibility,
+// transport, and IP restrictions of the ingress can be set with the ingress annotations.
+func ConvertIngress(spec scoretypes.Workload) (*ContainerAppIngress, error) {
+	primary, err := IngressPortName(spec)
+	if err != nil || primary == "" {
+		return nil, err
+	}
+
+	external, err := boolAnnotation(spec, IngressExternalAnnotation, true)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	ingress := &ContainerAppIngress{External: external}
+	var additionalNames []string
+	for _, name := range slices.Sorted(maps.Keys(spec.Service.Ports)) {
+		port := spec.Service.Ports[name]
+		targetPort := port.Port
+		if port.TargetPort != nil && *port.TargetPort != 0 {
+			targetPort = *port.TargetPort
+		}
+		transport := "auto"
+		if port.Protocol != nil {
+			switch *port.Protocol {
+			case scoretypes.ServicePortProtocolTCP:
+				transport = "tcp"
+			default:
+				return nil, fmt.Errorf("service: ports: %s: protocol '%s' is not supported by Azure Container Apps", name, *port.Protocol)
+			}
+		}
+
+		if name == primary {
+			ingress.TargetPort, ingress.Transport = targetPort, transport
+			if transport == "tcp" {
+				ingress.ExposedPort = port.Port
+			}
+		} else {
+			ingress.AdditionalPortMappings = append(ingress.AdditionalPortMappings, ContainerAppPortMapping{
+				External:    ingress.External,
+				TargetPort:  targetPort,
+				ExposedPort: port.Port,
+			})
+			additionalNames = append(additionalNames, name)
+		}
 	}
-	// Create a buffer to hold the generated container app
-	var buf bytes.Buffer
 
-	data := struct {
-		WorkloadName string
-		Properties   *ContainerAppProperties
-		Spec         scoretypes.Workload
-	}{
-		WorkloadName: workloadName,
-		Properties:   properties,
-		Spec:         spec,
+	if v, ok := workloadAnnotation(spec, IngressTransportAnnotation); ok {
+		if !slices.Contains([]string{"auto", "http", "http2", "tcp"}, v) {
+			return nil, fmt.Errorf("annotation '%s': '%s' is not a valid transport, expected one of auto, http, http2, tcp", IngressTransportAnnotation, v)
+		}
+		ingress.Transport, ingress.ExposedPort = v, 0
+		if v == "tcp" {
+			ingress.ExposedPort = spec.Service.Ports[primary].Port
+		}
 	}
 
-	// Execute the template with the properties
-	if err := t.Execute(&buf, data); err != nil {
-		return "", err
+	// the http ingress listens on 80 and 443 within the environment, the tcp ingress on its exposed port
+	exposed := map[int]string{80: primary, 443: primary}
+	if ingress.Transport == "tcp" {
+		exposed = map[int]string{ingress.ExposedPort: primary}
+	}
+	for i, mapping := range ingress.AdditionalPortMappings {
+		if other, ok := exposed[mapping.ExposedPort]; ok {
+			if other == primary && ingress.Transport != "tcp" {
+				return nil, fmt.Errorf("service: ports: %s: port %d clashes with port '%s', whose http ingress listens on ports 80 and 443", additionalNames[i], mapping.ExposedPort, other)
+			}
+			return nil, fmt.Errorf("service: ports: %s: port %d clashes with port '%s'", additionalNames[i], mapping.ExposedPort, other)
+		}
+		exposed[mapping.ExposedPort] = additionalNames[i]
+	}
+	if ingress.AllowInsecure, err = boolAnnotation(spec, IngressAllowInsecureAnnotation, false); err != nil {
+		return nil, err
 	}
-	// Return the generated container app as a string
-	return buf.String(), nil
 
+	allowRules, err := ipRulesAnnotation(spec, IngressIPAllowAnnotation, "Allow")
+	if err != nil {
+		return nil, err
+	}
+	denyRules, err := ipRulesAnnotation(spec, IngressIPDenyAnnotation, "Deny")
+	if err != nil {
+		return nil, err
+	}
+	if len(allowRules) > 0 && len(denyRules) > 0 {
+		return nil, fmt.Errorf("annotations '%s' and '%s' cannot be used together, Azure Container Apps requires all IP rules to have the same action", IngressIPAllowAnnotation, IngressIPDenyAnnotation)
+	}
+	ingress.IPSecurityRestrictions = append(allowRules, denyRules...)
+	return ingress, nil
 }
 
-// createContainerAppProperties creates the properties of an Azure Container App from a Score workload
-func createContainerAppProperties(spec scoretypes.Workload) (*ContainerAppProperties, error) {
+// createContainerAppProperties creates the properties of an Azure Container App from a Score workload. The resource
+// outputs are used to resolve the storage behind container volumes.
+func createContainerAppProperties(spec scoretypes.Workload, resOutputs map[string]framework.OutputLookupFunc, opts Options) (*ContainerAppProperties, error) {
 	properties := &ContainerAppProperties{
 		Configuration: ContainerAppConfiguration{
 			ActiveRevisionsMode: "Single",
@@ -268,35 +1056,68 @@ func createContainerAppProperties(spec scoretypes.Workload) (*ContainerAppProper
 	}
 
 	// Set ingress if service is defined
-	if spec.Service != nil && len(spec.Service.Ports) > 0 {
-		// Use the first port for ingress
-		var port int
-		for _, p := range spec.Service.Ports {
-			if p.TargetPort != nil {
-				port = *p.TargetPort
-			}
-			if port == 0 {
-				port = p.Port
+	ingress, err := ConvertIngress(spec)
+	if err != nil {
+		return nil, err
+	} else if ingress == nil {
+		annotations, _ := spec.Metadata["annotations"].(map[string]interface{})
+		for _, k := range slices.Sorted(maps.Keys(annotations)) {
+			if strings.HasPrefix(k, AnnotationPrefix+"ingress-") {
+				slog.Warn(fmt.Sprintf("Annotation '%s' is ignored since the workload has no service ports.", k))
 			}
-			break
 		}
-		properties.Configuration.Ingress = &ContainerAppIngress{
-			External:   true,
-			TargetPort: port,
-			Transport:  "auto",
+	}
+	properties.Configuration.Ingress = ingress
+
+	workloadName := fmt.Sprint(spec.Metadata["name"])
+	keyVaultIdentity, _ := workloadAnnotation(spec, KeyVaultIdentityAnnotation)
+
+	// The same limits policy applies to all containers, so that their total is validated as a whole
+	limitsPolicy := opts.LimitsPolicy
+	if limitsPolicy == "" {
+		limitsPolicy = LimitsPolicyFallback
+	}
+	if slices.ContainsFunc(slices.Collect(maps.Values(spec.Containers)), func(c scoretypes.Container) bool {
+		return c.Resources != nil && c.Resources.Limits != nil
+	}) {
+		slog.Info(fmt.Sprintf("%s: Mapping the resource limits of the containers with the '%s' limits policy.", workloadName, limitsPolicy))
+	}
+
+	probeAnnotations, err := probesAnnotation(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// The secrets of all containers, scale rules and registries share the configuration, so their names must be unique
+	// across the app
+	secretOwners := make(map[string]string)
+	addSecret := func(secret ContainerAppSecret, owner string) error {
+		if other, ok := secretOwners[secret.Name]; ok {
+			return fmt.Errorf("secret name '%s' is already used by %s", secret.Name, other)
 		}
+		secretOwners[secret.Name] = owner
+		properties.Configuration.Secrets = append(properties.Configuration.Secrets, secret)
+		return nil
+	}
+
+	// The volumes of all containers share the template, so their names must be unique across the app too
+	volumeOwners := make(map[string]string)
+	addVolume := func(volume ContainerAppVolume, owner string) error {
+		if other, ok := volumeOwners[volume.Name]; ok {
+			return fmt.Errorf("volume name '%s' is already used by %s", volume.Name, other)
+		}
+		volumeOwners[volume.Name] = owner
+		properties.Template.Volumes = append(properties.Template.Volumes, volume)
+		return nil
 	}
 
 	// Add containers
-	for name, container := range spec.Containers {
+	for _, name := range slices.Sorted(maps.Keys(spec.Containers)) {
+		container := spec.Containers[name]
 		// Create container
 		containerApp := ContainerAppContainer{
 			Name:  name,
 			Image: container.Image,
-			Resources: ContainerAppResources{
-				CPU:    0.25,    // Default CPU
-				Memory: "0.5Gi", // Default memory
-			},
 		}
 
 		// Add command if any
@@ -309,100 +1130,246 @@ func createContainerAppProperties(spec scoretypes.Workload) (*ContainerAppProper
 			containerApp.Args = container.Args
 		}
 
-		// Add environment variables
-		for key, value := range container.Variables {
+		// Add environment variables, the ones using secret outputs are passed through secrets
+		for _, key := range slices.Sorted(maps.Keys(container.Variables)) {
 			env := ContainerAppEnv{
 				Name:  key,
-				Value: value,
+				Value: container.Variables[key],
+			}
+			if _, isSecret := unmarkSecrets(env.Value); isSecret {
+				secret, err := convertSecretVariable(volumeName("env-"+name, key), env.Value, keyVaultIdentity)
+				if err != nil {
+					return nil, fmt.Errorf("containers: %s: variables: %s: %w", name, key, err)
+				} else if err := addSecret(secret, fmt.Sprintf("variable '%s' of container '%s'", key, name)); err != nil {
+					return nil, fmt.Errorf("containers: %s: variables: %s: %w", name, key, err)
+				}
+				env.Value, env.SecretRef = "", secret.Name
 			}
 
 			containerApp.Env = append(containerApp.Env, env)
 		}
 
-		// Add resources if defined
-		if container.Resources != nil {
-			if container.Resources.Limits != nil {
-				slog.Warn(fmt.Sprintf("%s: Resource limits are not supported in Azure Container Apps. Set the wanted values in the requests section.", name))
+		// Add resources, with the limits mapped onto them by the limits policy
+		resources, err := convertContainerResources(name, container.Resources, limitsPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("containers: %s: resources: %w", name, err)
+		}
+		containerApp.Resources = resources
+
+		// Add probes, with the configuration of the probes annotation
+		probes, err := convertProbes(name, container, probeAnnotations[name], opts.Strict)
+		if err != nil {
+			return nil, fmt.Errorf("containers: %s: %w", name, err)
+		}
+		containerApp.Probes = probes
+
+		// Add volume mounts, each one backed by its own volume
+		for _, target := range slices.Sorted(maps.Keys(container.Volumes)) {
+			volume, err := convertContainerVolume(name, target, container.Volumes[target], resOutputs)
+			if err != nil {
+				return nil, fmt.Errorf("containers: %s: volumes: %s: %w", name, target, err)
+			} else if err := addVolume(volume, fmt.Sprintf("volume '%s' of container '%s'", target, name)); err != nil {
+				return nil, fmt.Errorf("containers: %s: volumes: %s: %w", name, target, err)
 			}
-			// TODO: Evaluate Resource Requests for correct values from Azure
-			if container.Resources.Requests != nil {
-				if container.Resources.Requests.Cpu != nil {
-					cpuValue, err := parseCPU(*container.Resources.Requests.Cpu)
-					if err == nil {
-						containerApp.Resources.CPU = cpuValue
-					}
-				}
-				if container.Resources.Requests.Memory != nil {
-					containerApp.Resources.Memory = *container.Resources.Requests.Memory
-				}
+
+			mount := ContainerAppVolumeMount{
+				VolumeName: volume.Name,
+				MountPath:  target,
 			}
+			if container.Volumes[target].Path != nil {
+				mount.SubPath = *container.Volumes[target].Path
+			}
+			containerApp.VolumeMounts = append(containerApp.VolumeMounts, mount)
 		}
 
-		// Add probes if defined
-		if container.LivenessProbe != nil {
-			probe := ContainerAppProbe{}
-			if container.LivenessProbe.HttpGet != nil {
-				probe.HTTPGet = &ContainerAppHTTPGet{
-					Path: container.LivenessProbe.HttpGet.Path,
-					Port: container.LivenessProbe.HttpGet.Port,
-				}
-				if container.LivenessProbe.HttpGet.Scheme != nil {
-					probe.HTTPGet.Scheme = string(*container.LivenessProbe.HttpGet.Scheme)
-				}
-
-			} else if spec.Containers[name].LivenessProbe != nil {
-				if spec.Containers[name].LivenessProbe.HttpGet != nil {
-					probe.HTTPGet = &ContainerAppHTTPGet{
-						Path: spec.Containers[name].LivenessProbe.HttpGet.Path,
-						Port: spec.Containers[name].LivenessProbe.HttpGet.Port,
-					}
-					if spec.Containers[name].LivenessProbe.HttpGet.Scheme != nil {
-						probe.HTTPGet.Scheme = string(*spec.Containers[name].LivenessProbe.HttpGet.Scheme)
-					}
+		// Add files as secrets mounted from a single volume of type 'Secret'
+		if len(container.Files) > 0 {
+			secrets, volume, mounts, err := convertContainerFilesToSecrets(name, container.Files, keyVaultIdentity)
+			if err != nil {
+				return nil, fmt.Errorf("containers: %s: files: %w", name, err)
+			}
+			for i, secret := range secrets {
+				if err := addSecret(secret, fmt.Sprintf("file '%s' of container '%s'", mounts[i].MountPath, name)); err != nil {
+					return nil, fmt.Errorf("containers: %s: files: %s: %w", name, mounts[i].MountPath, err)
 				}
 			}
-			if probe != (ContainerAppProbe{}) {
-				probe.Type = "Liveness"
-				containerApp.Probes = append(containerApp.Probes, probe)
+			if err := addVolume(volume, fmt.Sprintf("the files of container '%s'", name)); err != nil {
+				return nil, fmt.Errorf("containers: %s: files: %w", name, err)
 			}
+			containerApp.VolumeMounts = append(containerApp.VolumeMounts, mounts...)
+			slices.SortFunc(containerApp.VolumeMounts, func(a, b ContainerAppVolumeMount) int {
+				return strings.Compare(a.MountPath, b.MountPath)
+			})
 		}
 
-		if container.ReadinessProbe != nil {
-			probe := ContainerAppProbe{}
-			if container.ReadinessProbe != nil {
-				if container.ReadinessProbe.HttpGet != nil {
-					probe.HTTPGet = &ContainerAppHTTPGet{
-						Path: container.ReadinessProbe.HttpGet.Path,
-						Port: container.ReadinessProbe.HttpGet.Port,
-					}
-					if container.ReadinessProbe.HttpGet.Scheme != nil {
-						probe.HTTPGet.Scheme = string(*container.ReadinessProbe.HttpGet.Scheme)
-					}
+		properties.Template.Containers = append(properties.Template.Containers, containerApp)
+	}
+
+	// Validate the total resources of the containers against the workload profile, which must be declared by an
+	// existing environment since the generated one has no workload profiles
+	profileType := ConsumptionWorkloadProfile
+	if v, ok := workloadAnnotation(spec, WorkloadProfileAnnotation); ok {
+		if opts.Environment == nil {
+			return nil, fmt.Errorf("annotation '%s': the generated container app environment has no workload profiles, the workload profile '%s' requires an existing environment", WorkloadProfileAnnotation, v)
+		}
+		properties.WorkloadProfileName, profileType = v, v
+	}
+	if v, ok := workloadAnnotation(spec, WorkloadProfileTypeAnnotation); ok {
+		profileType = v
+	}
+	if err := validateResources(workloadName, properties, profileType, opts.Strict); err != nil {
+		return nil, err
+	}
+
+	// Add the replica bounds and scale rules, the secrets used by the rules are added to the configuration
+	scale, scaleSecrets, err := convertScale(spec, resOutputs, keyVaultIdentity)
+	if err != nil {
+		return nil, err
+	}
+	properties.Template.Scale = scale
+	for _, secret := range scaleSecrets {
+		if err := addSecret(secret, "a scale rule"); err != nil {
+			return nil, fmt.Errorf("annotation '%s': %w", ScaleRulesAnnotation, err)
+		}
+	}
+
+	// Add the private registries of the images, the secrets holding their passwords are added to the configuration
+	registries, registrySecrets, err := convertRegistries(spec, resOutputs, keyVaultIdentity)
+	if err != nil {
+		return nil, err
+	}
+	properties.Configuration.Registries = registries
+	for _, secret := range registrySecrets {
+		if err := addSecret(secret, "a registry"); err != nil {
+			return nil, fmt.Errorf("annotation '%s': %w", RegistriesAnnotation, err)
+		}
+	}
+
+	// The scale rules can only pass the secrets declared on the container app to their triggers
+	if scale != nil {
+		for _, rule := range scale.Rules {
+			for _, trigger := range []*ContainerAppScaleRuleTrigger{rule.HTTP, rule.Custom} {
+				if trigger == nil {
+					continue
 				}
-				containerApp.Probes = append(containerApp.Probes, probe)
-			} else if spec.Containers[name].ReadinessProbe != nil {
-				if spec.Containers[name].ReadinessProbe.HttpGet != nil {
-					probe.HTTPGet = &ContainerAppHTTPGet{
-						Path: spec.Containers[name].ReadinessProbe.HttpGet.Path,
-						Port: spec.Containers[name].ReadinessProbe.HttpGet.Port,
-					}
-					if spec.Containers[name].ReadinessProbe.HttpGet.Scheme != nil {
-						probe.HTTPGet.Scheme = string(*spec.Containers[name].ReadinessProbe.HttpGet.Scheme)
+				for _, auth := range trigger.Auth {
+					if _, ok := secretOwners[auth.SecretRef]; !ok {
+						return nil, fmt.Errorf("annotation '%s': rule '%s': auth '%s': secretRef '%s' does not name a secret of the container app", ScaleRulesAnnotation, rule.Name, auth.TriggerParameter, auth.SecretRef)
 					}
 				}
 			}
-			if probe != (ContainerAppProbe{}) {
-				probe.Type = "Readiness"
-				containerApp.Probes = append(containerApp.Probes, probe)
-			}
 		}
-
-		properties.Template.Containers = append(properties.Template.Containers, containerApp)
 	}
 
+	slices.SortFunc(properties.Template.Volumes, func(a, b ContainerAppVolume) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	slices.SortFunc(properties.Configuration.Secrets, func(a, b ContainerAppSecret) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
 	return properties, nil
 }
 
+var (
+	// resourceReferenceRegex matches a volume source such as ${resources.data}
+	resourceReferenceRegex = regexp.MustCompile(`^\$\{resources\.([^.}]+)}$`)
+	repeatedDashRegex      = regexp.MustCompile(`-+`)
+)
+
+// convertContainerVolume converts a Score container volume to an Azure Container App volume. The volume source must
+// reference a resource that outputs the name of the managed environment storage to mount: 'storage_name', or
+// 'read_only_storage_name' for read-only volumes since the access mode is defined by the storage. The storage type
+// defaults to 'AzureFile' unless the resource outputs a 'storage_type'.
+func convertContainerVolume(containerName string, target string, volume scoretypes.ContainerVolume, resOutputs map[string]framework.OutputLookupFunc) (ContainerAppVolume, error) {
+	matches := resourceReferenceRegex.FindStringSubmatch(volume.Source)
+	if matches == nil {
+		return ContainerAppVolume{}, fmt.Errorf("source '%s' must reference a resource like ${resources.my-volume}", volume.Source)
+	}
+	lookup, ok := resOutputs[matches[1]]
+	if !ok || lookup == nil {
+		return ContainerAppVolume{}, fmt.Errorf("source '%s' references an unknown resource", volume.Source)
+	}
+
+	storageKey := "storage_name"
+	if volume.ReadOnly != nil && *volume.ReadOnly {
+		storageKey = "read_only_storage_name"
+	}
+	storageName, err := lookup(storageKey)
+	if err != nil {
+		return ContainerAppVolume{}, fmt.Errorf("resource '%s' does not output a '%s': %w", matches[1], storageKey, err)
+	}
+	storageType := "AzureFile"
+	if v, err := lookup("storage_type"); err == nil {
+		storageType = fmt.Sprint(v)
+	}
+	unmarkedStorageName, _ := unmarkSecrets(fmt.Sprint(storageName))
+	return ContainerAppVolume{
+		Name:        volumeName(containerName, target),
+		StorageType: storageType,
+		StorageName: unmarkedStorageName,
+	}, nil
+}
+
+// volumeName returns a valid volume name for the mount of a container by replacing any character that is not a
+// lowercase letter or digit with a '-'
+func volumeName(containerName string, target string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(containerName+"-"+target))
+	return strings.Trim(repeatedDashRegex.ReplaceAllString(name, "-"), "-")
+}
+
+// convertContainerFilesToSecrets converts the files of a container to Azure Container App secrets. The secrets are
+// exposed through one volume of type 'Secret' and each file is mounted at its target path using a sub path of that
+// volume. The files of a secret volume are never executable, so a warning is logged for a file mode that grants execute
+// permissions. Content that is a Key Vault secret output becomes a Key Vault reference read with the given identity.
+func convertContainerFilesToSecrets(containerName string, files map[string]scoretypes.ContainerFile, keyVaultIdentity string) ([]ContainerAppSecret, ContainerAppVolume, []ContainerAppVolumeMount, error) {
+	volume := ContainerAppVolume{
+		Name:        volumeName("files", containerName),
+		StorageType: "Secret",
+	}
+	secrets := make([]ContainerAppSecret, 0, len(files))
+	mounts := make([]ContainerAppVolumeMount, 0, len(files))
+	secretTargets := make(map[string]string, len(files))
+	for _, target := range slices.Sorted(maps.Keys(files)) {
+		file := files[target]
+		if file.Content == nil {
+			return nil, ContainerAppVolume{}, nil, fmt.Errorf("%s: missing 'content'", target)
+		}
+		if file.Mode != nil {
+			mode, err := strconv.ParseUint(*file.Mode, 8, 32)
+			if err != nil {
+				return nil, ContainerAppVolume{}, nil, fmt.Errorf("%s: mode: '%s' is not an octal file mode", target, *file.Mode)
+			}
+			if mode&0111 != 0 {
+				slog.Warn(fmt.Sprintf("%s: %s: File mode %s is not supported, files are mounted read-only from a secret volume.", containerName, target, *file.Mode))
+			}
+		}
+
+		secretName := volumeName("file-"+containerName, target)
+		if other, ok := secretTargets[secretName]; ok {
+			return nil, ContainerAppVolume{}, nil, fmt.Errorf("%s: secret name '%s' is already used by file '%s'", target, secretName, other)
+		}
+		secretTargets[secretName] = target
+		secret, err := convertSecretVariable(secretName, *file.Content, keyVaultIdentity)
+		if err != nil {
+			return nil, ContainerAppVolume{}, nil, fmt.Errorf("%s: %w", target, err)
+		}
+		secrets = append(secrets, secret)
+		volume.Secrets = append(volume.Secrets, ContainerAppSecretVolumeItem{SecretRef: secretName, Path: secretName})
+		mounts = append(mounts, ContainerAppVolumeMount{
+			VolumeName: volume.Name,
+			MountPath:  target,
+			SubPath:    secretName,
+		})
+	}
+	return secrets, volume, mounts, nil
+}
+
 // parseCPU parses a CPU value from a string to a float64
 func parseCPU(cpu string) (float64, error) {
 	// Check if the CPU value is in millicores (e.g., "500m")
@@ -421,12 +1388,7 @@ func parseCPU(cpu string) (float64, error) {
 
 // parseFloat parses a string to a float64
 func parseFloat(s string) (float64, error) {
-	var value float64
-	_, err := fmt.Sscanf(s, "%f", &value)
-	if err != nil {
-		return 0, err
-	}
-	return value, nil
+	return strconv.ParseFloat(strings.TrimSpace(s), 64)
 }
 
 func convertContainerVariables(input scoretypes.ContainerVariables, sf func(string) (string, error)) (map[string]string, error) {
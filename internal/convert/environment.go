@@ -0,0 +1,291 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/score-spec/score-go/framework"
+
+	"github.com/score-spec/score-aca/internal/state"
+)
+
+// ExistingEnvironment identifies an existing container app environment that the workloads are deployed to instead of
+// declaring a new one. The resource group and subscription are only set when the environment is outside of the
+// resource group of the deployment.
+type ExistingEnvironment struct {
+	Name           string
+	ResourceGroup  string
+	SubscriptionID string
+}
+
+// NewExistingEnvironment returns the existing environment identified by either a resource id, or a name with an
+// optional resource group. It returns nil if none is set.
+func NewExistingEnvironment(id string, name string, resourceGroup string) (*ExistingEnvironment, error) {
+	switch {
+	case id != "" && (name != "" || resourceGroup != ""):
+		return nil, fmt.Errorf("an environment id cannot be combined with an environment name or resource group")
+	case id != "":
+		subscriptionID, resourceGroup, name, err := parseResourceID(id, "Microsoft.App/managedEnvironments", "container app environment")
+		if err != nil {
+			return nil, err
+		}
+		return &ExistingEnvironment{Name: name, ResourceGroup: resourceGroup, SubscriptionID: subscriptionID}, nil
+	case resourceGroup != "" && name == "":
+		return nil, fmt.Errorf("an environment resource group needs an environment name")
+	case name != "":
+		return &ExistingEnvironment{Name: name, ResourceGroup: resourceGroup}, nil
+	}
+	return nil, nil
+}
+
+// parseResourceID parses the id of a resource of the given type like
+// /subscriptions/<id>/resourceGroups/<name>/providers/Microsoft.App/managedEnvironments/<name> and returns its
+// subscription id, resource group, and name. The description names the kind of resource in the error.
+func parseResourceID(id string, resourceType string, description string) (string, string, string, error) {
+	parts := strings.Split(strings.TrimSuffix(id, "/"), "/")
+	typeParts := strings.Split(resourceType, "/")
+	if len(parts) != 9 || parts[0] != "" || !strings.EqualFold(parts[1], "subscriptions") || !strings.EqualFold(parts[3], "resourceGroups") ||
+		!strings.EqualFold(parts[5], "providers") || !strings.EqualFold(parts[6], typeParts[0]) || !strings.EqualFold(parts[7], typeParts[1]) ||
+		parts[2] == "" || parts[4] == "" || parts[8] == "" {
+		return "", "", "", fmt.Errorf("'%s' is not the resource id of a %s, expected /subscriptions/<id>/resourceGroups/<name>/providers/%s/<name>", id, description, resourceType)
+	}
+	return parts[2], parts[4], parts[8], nil
+}
+
+// resolveEnvironment returns the options with the existing environment set by the environment annotations of the
+// workloads, unless it is already set. All workloads that set the annotations must reference the same environment.
+func resolveEnvironment(currentState *state.State, workloadNames []string, opts Options) (Options, error) {
+	if opts.Environment != nil {
+		return opts, nil
+	}
+	var firstWorkload string
+	for _, workloadName := range workloadNames {
+		spec := currentState.Workloads[workloadName].Spec
+		id, _ := workloadAnnotation(spec, EnvironmentIDAnnotation)
+		name, _ := workloadAnnotation(spec, EnvironmentNameAnnotation)
+		resourceGroup, _ := workloadAnnotation(spec, EnvironmentResourceGroupAnnotation)
+		env, err := NewExistingEnvironment(id, name, resourceGroup)
+		if err != nil {
+			return opts, fmt.Errorf("workload: %s: environment annotations: %w", workloadName, err)
+		} else if env == nil {
+			continue
+		} else if opts.Environment != nil && *opts.Environment != *env {
+			return opts, fmt.Errorf("workload: %s: environment annotations: the workload references a different environment than workload '%s'", workloadName, firstWorkload)
+		}
+		opts.Environment, firstWorkload = env, workloadName
+	}
+	return opts, nil
+}
+
+// environmentScope returns the Bicep scope of an existing environment outside of the resource group of the deployment,
+// or an empty string
+func environmentScope(env *ExistingEnvironment) string {
+	switch {
+	case env == nil || env.ResourceGroup == "":
+		return ""
+	case env.SubscriptionID != "":
+		return "resourceGroup(environmentSubscriptionId, environmentResourceGroup)"
+	}
+	return "resourceGroup(environmentResourceGroup)"
+}
+
+// writeEnvironmentScopeParams writes the parameters holding the scope of an existing environment, with the given
+// default values if set
+func writeEnvironmentScopeParams(w *bicepWriter, env *ExistingEnvironment, withDefaults bool) {
+	if environmentScope(env) == "" {
+		return
+	}
+	params := []struct{ name, value string }{{"environmentResourceGroup", env.ResourceGroup}}
+	if env.SubscriptionID != "" {
+		params = append([]struct{ name, value string }{{"environmentSubscriptionId", env.SubscriptionID}}, params...)
+	}
+	for _, p := range params {
+		if withDefaults {
+			w.WriteParam(p.name, "string", p.value)
+		} else {
+			w.WriteLine(fmt.Sprintf("param %s string", p.name))
+		}
+	}
+}
+
+// environmentScopeParams returns the module parameters that pass the scope of an existing environment
+func environmentScopeParams(env *ExistingEnvironment) map[string]bicepExpression {
+	params := map[string]bicepExpression{}
+	if environmentScope(env) != "" {
+		params["environmentResourceGroup"] = "environmentResourceGroup"
+		if env.SubscriptionID != "" {
+			params["environmentSubscriptionId"] = "environmentSubscriptionId"
+		}
+	}
+	return params
+}
+
+// generateExistingContainerAppEnvironment generates the reference to an existing container app environment, with its
+// scope if it is in another resource group
+func generateExistingContainerAppEnvironment(env *ExistingEnvironment) string {
+	scope := environmentScope(env)
+	if scope == "" {
+		return bicepExistingContainerAppEnvironment
+	}
+	return strings.Replace(bicepExistingContainerAppEnvironment, "  name: environmentName\n", "  name: environmentName\n  scope: "+scope+"\n", 1)
+}
+
+// environmentChildRegex matches the declaration of a child resource of the container app environment
+var environmentChildRegex = regexp.MustCompile(`\bparent:\s*containerAppEnvironment\b`)
+
+// checkEnvironmentResources returns an error for the first resource that declares children of an existing environment
+// scoped to a resource group, which fails to compile since Bicep cannot deploy them to another scope
+func checkEnvironmentResources(env *ExistingEnvironment, resourcesBicep []ResourceBicep) error {
+	if environmentScope(env) == "" {
+		return nil
+	}
+	for _, rb := range resourcesBicep {
+		if environmentChildRegex.MatchString(rb.Bicep) {
+			return fmt.Errorf("resource '%s': the Bicep declares a child resource of the container app environment, which cannot be deployed to its resource group '%s', reference the environment by its name only when it is in the resource group of the deployment", rb.Uid, env.ResourceGroup)
+		}
+	}
+	return nil
+}
+
+// LogAnalyticsWorkspace identifies the Log Analytics workspace that receives the application logs of the container app
+// environment. A new workspace is declared unless it is an existing one.
+type LogAnalyticsWorkspace struct {
+	Name           string
+	ResourceGroup  string
+	SubscriptionID string
+	Existing       bool
+}
+
+// NewLogAnalyticsWorkspace returns the existing workspace identified by a resource id, or the new workspace with the
+// given name. It returns nil if none is set.
+func NewLogAnalyticsWorkspace(id string, name string) (*LogAnalyticsWorkspace, error) {
+	switch {
+	case id != "" && name != "":
+		return nil, fmt.Errorf("a Log Analytics workspace id cannot be combined with a workspace name")
+	case id != "":
+		subscriptionID, resourceGroup, name, err := parseResourceID(id, "Microsoft.OperationalInsights/workspaces", "Log Analytics workspace")
+		if err != nil {
+			return nil, err
+		}
+		return &LogAnalyticsWorkspace{Name: name, ResourceGroup: resourceGroup, SubscriptionID: subscriptionID, Existing: true}, nil
+	case name != "":
+		return &LogAnalyticsWorkspace{Name: name}, nil
+	}
+	return nil, nil
+}
+
+// EnvironmentResourceType is the type of the Score resource whose outputs configure the container app environment
+const EnvironmentResourceType = "environment"
+
+// resolveLogAnalytics returns the options with the Log Analytics workspace set by the outputs of the environment
+// resources of the workloads, unless it is already set. All environment resources must reference the same workspace.
+func resolveLogAnalytics(currentState *state.State, workloadNames []string, opts Options) (Options, error) {
+	if opts.LogAnalytics == nil {
+		var firstResource framework.ResourceUid
+		for _, workloadName := range workloadNames {
+			spec := currentState.Workloads[workloadName].Spec
+			for _, resName := range slices.Sorted(maps.Keys(spec.Resources)) {
+				res := spec.Resources[resName]
+				if res.Type != EnvironmentResourceType {
+					continue
+				}
+				resUid := framework.NewResourceUid(workloadName, resName, res.Type, res.Class, res.Id)
+				outputs := currentState.Resources[resUid].Outputs
+				id, _ := outputs["log_analytics_workspace_id"].(string)
+				name, _ := outputs["log_analytics_workspace_name"].(string)
+				logs, err := NewLogAnalyticsWorkspace(id, name)
+				if err != nil {
+					return opts, fmt.Errorf("%s: %w", resUid, err)
+				} else if logs == nil {
+					continue
+				} else if opts.LogAnalytics != nil && *opts.LogAnalytics != *logs {
+					return opts, fmt.Errorf("%s: the resource references a different Log Analytics workspace than '%s'", resUid, firstResource)
+				}
+				opts.LogAnalytics, firstResource = logs, resUid
+			}
+		}
+	}
+	if opts.LogAnalytics != nil && opts.Environment != nil {
+		slog.Warn(fmt.Sprintf("The Log Analytics workspace '%s' is ignored since the workloads are deployed to the existing environment '%s'.", opts.LogAnalytics.Name, opts.Environment.Name))
+		opts.LogAnalytics = nil
+	}
+	return opts, nil
+}
+
+// resolveOptions returns the options with the existing environment and the Log Analytics workspace resolved from the
+// workloads
+func resolveOptions(currentState *state.State, workloadNames []string, opts Options) (Options, error) {
+	opts, err := resolveEnvironment(currentState, workloadNames, opts)
+	if err != nil {
+		return opts, err
+	}
+	return resolveLogAnalytics(currentState, workloadNames, opts)
+}
+
+// writeLogAnalyticsParams writes the parameters holding the name and scope of the Log Analytics workspace
+func writeLogAnalyticsParams(w *bicepWriter, logs *LogAnalyticsWorkspace) {
+	if logs == nil {
+		return
+	}
+	w.WriteParam("logAnalyticsWorkspaceName", "string", logs.Name)
+	if logs.Existing {
+		w.WriteParam("logAnalyticsSubscriptionId", "string", logs.SubscriptionID)
+		w.WriteParam("logAnalyticsResourceGroup", "string", logs.ResourceGroup)
+	}
+}
+
+// generateLogAnalyticsWorkspace generates the declaration of a new Log Analytics workspace, or the reference to an
+// existing one, and the container app environment that sends its logs to it
+func generateLogAnalyticsWorkspace(logs *LogAnalyticsWorkspace) string {
+	w := new(bicepWriter)
+	w.WriteLine("// Log Analytics Workspace")
+	if logs.Existing {
+		w.WriteLine("resource logAnalyticsWorkspace 'Microsoft.OperationalInsights/workspaces@2022-10-01' existing = {")
+		w.WriteLine("  name: logAnalyticsWorkspaceName")
+		w.WriteLine("  scope: resourceGroup(logAnalyticsSubscriptionId, logAnalyticsResourceGroup)")
+		w.WriteLine("}")
+	} else {
+		w.WriteResource("logAnalyticsWorkspace", "Microsoft.OperationalInsights/workspaces@2022-10-01", bicepObject{
+			{"name", bicepExpression("logAnalyticsWorkspaceName")},
+			{"location", bicepExpression("location")},
+			{"properties", bicepObject{
+				{"sku", bicepObject{{"name", "PerGB2018"}}},
+				{"retentionInDays", 30},
+			}},
+		})
+	}
+	w.WriteLine("")
+	w.WriteLine("// Container App Environment")
+	w.WriteResource("containerAppEnvironment", "Microsoft.App/managedEnvironments@2024-03-01", bicepObject{
+		{"name", bicepExpression("environmentName")},
+		{"location", bicepExpression("location")},
+		{"properties", bicepObject{
+			{"appLogsConfiguration", bicepObject{
+				{"destination", "log-analytics"},
+				{"logAnalyticsConfiguration", bicepObject{
+					{"customerId", bicepExpression("logAnalyticsWorkspace.properties.customerId")},
+					{"sharedKey", bicepExpression("logAnalyticsWorkspace.listKeys().primarySharedKey")},
+				}},
+			}},
+		}},
+	})
+	return w.String()
+}
@@ -0,0 +1,194 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/score-spec/score-go/framework"
+	scoretypes "github.com/score-spec/score-go/types"
+
+	"github.com/score-spec/score-aca/internal/state"
+)
+
+const (
+	// IdentityResourceType is the type of the Score resources whose 'id' output is the resource id of a user-assigned
+	// identity attached to the container app
+	IdentityResourceType = "identity"
+	// roleAssignmentType is the resource type of role assignments
+	roleAssignmentType = "Microsoft.Authorization/roleAssignments@2022-04-01"
+)
+
+// workloadRoleAssignment is a role granted to the system-assigned identity of a container app on a resource declared
+// in the Bicep of a provisioner
+type workloadRoleAssignment struct {
+	ResourceUid framework.ResourceUid
+	ResName     string
+	state.RoleAssignment
+	// ScopeType is the resource type of the scope with its api version
+	ScopeType string
+}
+
+// resourceDeclarationRegex returns a regex matching the declaration of a resource with the given symbolic name and
+// capturing its type
+func resourceDeclarationRegex(symbol string) *regexp.Regexp {
+	return regexp.MustCompile(`(?m)^\s*resource\s+` + regexp.QuoteMeta(symbol) + `\s+'([^']+)'`)
+}
+
+// DeclaresResource returns true if the Bicep declarations contain a resource with the given symbolic name
+func DeclaresResource(bicep string, symbol string) bool {
+	return symbol != "" && resourceDeclarationRegex(symbol).MatchString(bicep)
+}
+
+// convertIdentity returns the managed identities requested by the annotations of a workload and by the identity
+// resources it uses, or nil if it requests none. The system-assigned identity is enabled when roles are granted to the
+// container app.
+func convertIdentity(workload bicepWorkload) (*ContainerAppIdentity, error) {
+	spec := workload.Spec
+	systemAssigned, err := boolAnnotation(spec, SystemAssignedIdentityAnnotation, len(workload.RoleAssignments) > 0)
+	if err != nil {
+		return nil, err
+	} else if !systemAssigned && len(workload.RoleAssignments) > 0 {
+		return nil, fmt.Errorf("annotation '%s': the system-assigned identity is needed by the role assignments of the resources", SystemAssignedIdentityAnnotation)
+	}
+	userAssigned := make([]string, 0)
+	if v, ok := workloadAnnotation(spec, UserAssignedIdentitiesAnnotation); ok {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id == "" {
+				continue
+			} else if _, _, _, err := parseResourceID(id, "Microsoft.ManagedIdentity/userAssignedIdentities", "user-assigned identity"); err != nil {
+				return nil, fmt.Errorf("annotation '%s': %w", UserAssignedIdentitiesAnnotation, err)
+			} else if !slices.Contains(userAssigned, id) {
+				userAssigned = append(userAssigned, id)
+			}
+		}
+	}
+	for _, resName := range slices.Sorted(maps.Keys(spec.Resources)) {
+		if spec.Resources[resName].Type != IdentityResourceType {
+			continue
+		}
+		lookup, ok := workload.ResOutputs[resName]
+		if !ok {
+			return nil, fmt.Errorf("resource '%s': no outputs", resName)
+		}
+		v, err := lookup("id")
+		if err != nil {
+			return nil, fmt.Errorf("resource '%s': identity resources must output the 'id' of a user-assigned identity: %w", resName, err)
+		}
+		id, ok := v.(string)
+		if !ok || id == "" {
+			return nil, fmt.Errorf("resource '%s': the 'id' output of identity resources must be a string", resName)
+		} else if _, isSecret := unmarkSecrets(id); isSecret {
+			return nil, fmt.Errorf("resource '%s': the 'id' output of identity resources cannot be a secret", resName)
+		} else if !slices.Contains(userAssigned, id) {
+			userAssigned = append(userAssigned, id)
+		}
+	}
+	return newContainerAppIdentity(systemAssigned, userAssigned), nil
+}
+
+// newContainerAppIdentity returns the identity block enabling the system-assigned identity and attaching the
+// user-assigned identities, or nil if there are none
+func newContainerAppIdentity(systemAssigned bool, userAssigned []string) *ContainerAppIdentity {
+	switch {
+	case systemAssigned && len(userAssigned) > 0:
+		return &ContainerAppIdentity{Type: "SystemAssigned,UserAssigned", UserAssignedIdentities: userAssigned}
+	case systemAssigned:
+		return &ContainerAppIdentity{Type: "SystemAssigned"}
+	case len(userAssigned) > 0:
+		return &ContainerAppIdentity{Type: "UserAssigned", UserAssignedIdentities: userAssigned}
+	}
+	return nil
+}
+
+// workloadRoleAssignments returns the role assignments of the resources used by a workload in the order of the
+// resource names
+func workloadRoleAssignments(currentState *state.State, workloadName string, spec scoretypes.Workload) []workloadRoleAssignment {
+	out := make([]workloadRoleAssignment, 0)
+	for _, resName := range slices.Sorted(maps.Keys(spec.Resources)) {
+		res := spec.Resources[resName]
+		resUid := framework.NewResourceUid(workloadName, resName, res.Type, res.Class, res.Id)
+		resState := currentState.Resources[resUid]
+		for _, ra := range resState.Extras.RoleAssignments {
+			scopeType := ""
+			if m := resourceDeclarationRegex(ra.Scope).FindStringSubmatch(resState.Extras.Bicep); m != nil {
+				scopeType = m[1]
+			}
+			out = append(out, workloadRoleAssignment{ResourceUid: resUid, ResName: resName, RoleAssignment: ra, ScopeType: scopeType})
+		}
+	}
+	return out
+}
+
+// writeRoleAssignment writes a role assignment granting a role on the scope to a principal. The name of the
+// assignment is derived from the scope, the resource holding the principal and the role, so that it is stable across
+// deployments.
+func writeRoleAssignment(w *bicepWriter, symbol string, scope string, principalResource string, principalID string, role string) {
+	w.WriteResource(symbol, roleAssignmentType, bicepObject{
+		{"name", bicepExpression(fmt.Sprintf("guid(%s.id, %s.id, '%s')", scope, principalResource, role))},
+		{"scope", bicepExpression(scope)},
+		{"properties", bicepObject{
+			{"roleDefinitionId", bicepExpression(fmt.Sprintf("subscriptionResourceId('Microsoft.Authorization/roleDefinitions', '%s')", role))},
+			{"principalId", bicepExpression(principalID)},
+			{"principalType", "ServicePrincipal"},
+		}},
+	})
+}
+
+// generateRoleAssignments generates the role assignments granted to the system-assigned identity of the container app
+// of a workload
+func generateRoleAssignments(workload bicepWorkload) string {
+	w := new(bicepWriter)
+	usedSymbols := make(map[string]bool)
+	for _, ra := range workload.RoleAssignments {
+		symbol := BicepSymbol(workload.AppSymbol, ra.Scope, "roleAssignment")
+		for i := 2; usedSymbols[symbol]; i++ {
+			symbol = BicepSymbol(workload.AppSymbol, ra.Scope, "roleAssignment", strconv.Itoa(i))
+		}
+		usedSymbols[symbol] = true
+		w.WriteLine("")
+		writeRoleAssignment(w, symbol, ra.Scope, workload.AppSymbol, workload.AppSymbol+".identity.principalId", ra.Role)
+	}
+	return w.String()
+}
+
+// roleAssignmentScopeParam returns the name of the workload module parameter holding the name of the scope of a role
+// assignment
+func roleAssignmentScopeParam(ra workloadRoleAssignment) string {
+	return BicepSymbol(ra.ResName, ra.Scope, "name")
+}
+
+// generateExistingRoleAssignmentScopes generates the references to the scopes of the role assignments of a workload
+// module, the names of the scopes are passed as parameters by the main module
+func generateExistingRoleAssignmentScopes(roleAssignments []workloadRoleAssignment) string {
+	w := new(bicepWriter)
+	seen := make(map[string]bool)
+	for _, ra := range roleAssignments {
+		if seen[ra.Scope] {
+			continue
+		}
+		seen[ra.Scope] = true
+		w.WriteLine("")
+		w.WriteLine(fmt.Sprintf("resource %s %s existing = {", ra.Scope, bicepString(ra.ScopeType)))
+		w.WriteLine(fmt.Sprintf("  name: %s", roleAssignmentScopeParam(ra)))
+		w.WriteLine("}")
+	}
+	return w.String()
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	scoretypes "github.com/score-spec/score-go/types"
+)
+
+const (
+	// JobTriggerManual starts the executions of a job on demand
+	JobTriggerManual = "Manual"
+	// JobTriggerSchedule starts the executions of a job on a cron schedule
+	JobTriggerSchedule = "Schedule"
+	// JobTriggerEvent starts the executions of a job from KEDA scale rules
+	JobTriggerEvent = "Event"
+)
+
+// defaultJobReplicaTimeout is the default maximum number of seconds that a replica of a job can run
+const defaultJobReplicaTimeout = 1800
+
+// convertJob returns the configuration of the Azure Container App Job that runs the workload when the job trigger
+// annotation is set, or nil if the workload runs as a container app. The containers, secrets, and volumes of the job
+// are the ones of the container app properties. Event jobs take their scale rules from the scale annotations, the
+// replica bounds become the bounds of the number of executions; the scale is removed from the template.
+func convertJob(spec scoretypes.Workload, properties *ContainerAppProperties) (*ContainerAppJobConfiguration, error) {
+	trigger, ok := workloadAnnotation(spec, JobTriggerAnnotation)
+	if !ok {
+		return nil, nil
+	}
+	job := &ContainerAppJobConfiguration{ReplicaTimeout: defaultJobReplicaTimeout, Parallelism: 1, ReplicaCompletionCount: 1}
+	for _, t := range []string{JobTriggerManual, JobTriggerSchedule, JobTriggerEvent} {
+		if strings.EqualFold(strings.TrimSpace(trigger), t) {
+			job.TriggerType = t
+		}
+	}
+	if job.TriggerType == "" {
+		return nil, fmt.Errorf("annotation '%s': '%s' is not a trigger type, expected one of %s, %s, or %s", JobTriggerAnnotation, trigger, JobTriggerManual, JobTriggerSchedule, JobTriggerEvent)
+	}
+	if properties.Configuration.Ingress != nil {
+		return nil, fmt.Errorf("annotation '%s': workloads with service ports cannot run as jobs", JobTriggerAnnotation)
+	}
+
+	for _, setting := range []struct {
+		annotation string
+		target     *int
+		min        int
+	}{
+		{JobParallelismAnnotation, &job.Parallelism, 1},
+		{JobReplicaCompletionCountAnnotation, &job.ReplicaCompletionCount, 1},
+		{JobReplicaTimeoutAnnotation, &job.ReplicaTimeout, 1},
+		{JobRetryLimitAnnotation, &job.ReplicaRetryLimit, 0},
+	} {
+		if v, ok, err := intAnnotation(spec, setting.annotation, setting.min, math.MaxInt32); err != nil {
+			return nil, err
+		} else if ok {
+			*setting.target = v
+		}
+	}
+
+	schedule, hasSchedule := workloadAnnotation(spec, JobScheduleAnnotation)
+	if job.TriggerType == JobTriggerSchedule {
+		if !hasSchedule {
+			return nil, fmt.Errorf("annotation '%s': %s jobs need a cron expression in the '%s' annotation", JobTriggerAnnotation, JobTriggerSchedule, JobScheduleAnnotation)
+		} else if len(strings.Fields(schedule)) != 5 {
+			return nil, fmt.Errorf("annotation '%s': '%s' is not a cron expression with 5 fields", JobScheduleAnnotation, schedule)
+		}
+		job.CronExpression = strings.Join(strings.Fields(schedule), " ")
+	} else if hasSchedule {
+		return nil, fmt.Errorf("annotation '%s': only %s jobs have a schedule", JobScheduleAnnotation, JobTriggerSchedule)
+	}
+
+	if job.TriggerType == JobTriggerEvent {
+		if properties.Template.Scale == nil || len(properties.Template.Scale.Rules) == 0 {
+			return nil, fmt.Errorf("annotation '%s': %s jobs need at least one scale rule from the '%s' annotation", JobTriggerAnnotation, JobTriggerEvent, ScaleRulesAnnotation)
+		}
+		for _, r := range properties.Template.Scale.Rules {
+			if r.Custom == nil {
+				return nil, fmt.Errorf("scale rule '%s': http rules are not supported by jobs", r.Name)
+			}
+		}
+		job.Scale = properties.Template.Scale
+	} else if properties.Template.Scale != nil {
+		return nil, fmt.Errorf("annotation '%s': scale annotations are only supported by %s jobs", JobTriggerAnnotation, JobTriggerEvent)
+	}
+	properties.Template.Scale = nil
+	return job, nil
+}
+
+// generateJobConfiguration generates the trigger settings of the configuration of a container app job
+func generateJobConfiguration(job ContainerAppJobConfiguration) bicepObject {
+	out := bicepObject{
+		{"triggerType", job.TriggerType},
+		{"replicaTimeout", job.ReplicaTimeout},
+		{"replicaRetryLimit", job.ReplicaRetryLimit},
+	}
+	trigger := bicepObject{}
+	if job.CronExpression != "" {
+		trigger = append(trigger, bicepProperty{"cronExpression", job.CronExpression})
+	}
+	trigger = append(trigger, bicepProperty{"parallelism", job.Parallelism}, bicepProperty{"replicaCompletionCount", job.ReplicaCompletionCount})
+	if job.Scale != nil {
+		scale := bicepObject{}
+		if job.Scale.MinReplicas != nil {
+			scale = append(scale, bicepProperty{"minExecutions", *job.Scale.MinReplicas})
+		}
+		if job.Scale.MaxReplicas != 0 {
+			scale = append(scale, bicepProperty{"maxExecutions", job.Scale.MaxReplicas})
+		}
+		rules := bicepArray{}
+		for _, r := range job.Scale.Rules {
+			rules = append(rules, append(bicepObject{{"name", r.Name}}, generateScaleRuleTrigger(*r.Custom)...))
+		}
+		scale = append(scale, bicepProperty{"rules", rules})
+		trigger = append(trigger, bicepProperty{"scale", scale})
+	}
+	switch job.TriggerType {
+	case JobTriggerManual:
+		out = append(out, bicepProperty{"manualTriggerConfig", trigger})
+	case JobTriggerSchedule:
+		out = append(out, bicepProperty{"scheduleTriggerConfig", trigger})
+	case JobTriggerEvent:
+		out = append(out, bicepProperty{"eventTriggerConfig", trigger})
+	}
+	return out
+}
@@ -0,0 +1,346 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/score-spec/score-go/framework"
+
+	"github.com/score-spec/score-aca/internal/state"
+)
+
+const (
+	// MainModuleFile is the name of the file that declares the environment and references all other modules
+	MainModuleFile = "main.bicep"
+	// ModulesDirectory is the directory holding the module of each workload
+	ModulesDirectory = "modules"
+	// ResourceModulesDirectory is the directory holding the module of each provisioned resource
+	ResourceModulesDirectory = "modules/resources"
+)
+
+var (
+	// bicepWordRegex matches the words of Bicep declarations, which include the symbolic names that they use
+	bicepWordRegex = regexp.MustCompile(`\w+`)
+	// bicepDeclarationRegex matches a top-level declaration of Bicep and captures its symbolic name
+	bicepDeclarationRegex = regexp.MustCompile(`(?m)^(?:param|var|resource|module)\s+(\w+)`)
+	// requiredParamRegex matches a top-level param without a default value and captures its decorators and name
+	requiredParamRegex = regexp.MustCompile(`(?m)^((?:@.*\n)*)param\s+(\w+)\s+\w+[ \t]*$`)
+)
+
+// moduleOutput is a resource output that contains a Bicep expression. It is declared as an output of the resource
+// module and passed by the main module to the parameter of each workload module that uses it.
+type moduleOutput struct {
+	ResourceUid framework.ResourceUid
+	// Name is the name of the output in the resource module
+	Name string
+	// Param is the name of the parameter in the workload module
+	Param string
+	// Value is the content of the Bicep string holding the expression
+	Value string
+	// Secret is true if the output is a secret output of the resource, its output and parameter are then secure
+	Secret bool
+}
+
+// Modules converts a set of Score workloads to Bicep modules. The returned map holds the content of each file by its
+// relative path: a main.bicep that declares the shared container app environment once and references a module for
+// each workload and each provisioned resource. Resource outputs containing Bicep expressions are evaluated by the
+// resource module and passed to the workload modules as parameters.
+func Modules(currentState *state.State, workloadNames []string, opts Options) (map[string]string, error) {
+	opts, err := resolveOptions(currentState, workloadNames, opts)
+	if err != nil {
+		return nil, err
+	}
+	workloadOutputs := make(map[string][]moduleOutput, len(workloadNames))
+	wrapOutputs := func(workloadName string, resName string, resUid framework.ResourceUid, lookup framework.OutputLookupFunc) framework.OutputLookupFunc {
+		return func(keys ...string) (interface{}, error) {
+			v, err := lookup(keys...)
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := v.(string); ok && strings.Contains(s, "${") {
+				out := moduleOutput{
+					ResourceUid: resUid,
+					Name:        BicepSymbol(keys...),
+					Param:       BicepSymbol(resName, strings.Join(keys, "_")),
+					Value:       s,
+					Secret:      slices.Contains(currentState.Resources[resUid].Extras.SecretOutputs, strings.Join(keys, ".")),
+				}
+				if !slices.Contains(workloadOutputs[workloadName], out) {
+					workloadOutputs[workloadName] = append(workloadOutputs[workloadName], out)
+				}
+				return "${" + out.Param + "}", nil
+			}
+			return v, nil
+		}
+	}
+	workloads, resourcesBicep, err := prepareWorkloads(currentState, workloadNames, opts, wrapOutputs)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResourceReferences(resourcesBicep); err != nil {
+		return nil, err
+	} else if err := checkEnvironmentResources(opts.Environment, resourcesBicep); err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string, len(workloads)+len(resourcesBicep)+1)
+	resourceOutputs := make(map[framework.ResourceUid][]moduleOutput)
+	for _, workload := range workloads {
+		// the container app is generated first since resolving the volumes looks up further resource outputs
+		moduleWorkload := workload
+		moduleWorkload.AppSymbol, moduleWorkload.AppNameParam, moduleWorkload.FQDNOutput = "containerApp", "containerAppName", "containerAppFQDN"
+		moduleWorkload.InModule = true
+		containerApp, err := generateContainerApp(moduleWorkload, false)
+		if err != nil {
+			return nil, fmt.Errorf("workload: %s: failed to convert to Bicep: failed to generate container app: %w", workload.Name, err)
+		}
+
+		// the scopes of the role assignments are referenced by name in the workload module
+		for _, ra := range workload.RoleAssignments {
+			if strings.Count(strings.Split(ra.ScopeType, "@")[0], "/") != 1 {
+				return nil, fmt.Errorf("workload: %s: resource '%s': the scope '%s' of a role assignment must be a top-level resource when generating modules", workload.Name, ra.ResName, ra.Scope)
+			}
+			out := moduleOutput{
+				ResourceUid: ra.ResourceUid,
+				Name:        BicepSymbol(ra.Scope, "name"),
+				Param:       roleAssignmentScopeParam(ra),
+				Value:       "${" + ra.Scope + ".name}",
+			}
+			if !slices.Contains(workloadOutputs[workload.Name], out) {
+				workloadOutputs[workload.Name] = append(workloadOutputs[workload.Name], out)
+			}
+		}
+		params := workloadOutputs[workload.Name]
+		slices.SortFunc(params, func(a, b moduleOutput) int {
+			return strings.Compare(a.Param, b.Param)
+		})
+		workloadOutputs[workload.Name] = params
+		for _, p := range params {
+			if !slices.ContainsFunc(resourceOutputs[p.ResourceUid], func(o moduleOutput) bool { return o.Name == p.Name }) {
+				resourceOutputs[p.ResourceUid] = append(resourceOutputs[p.ResourceUid], p)
+			}
+		}
+
+		files[workloadModulePath(workload.Name)] = generateWorkloadModule(moduleWorkload, containerApp, params)
+	}
+	for _, rb := range resourcesBicep {
+		outputs := resourceOutputs[rb.Uid]
+		slices.SortFunc(outputs, func(a, b moduleOutput) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		files[resourceModulePath(rb.Uid)] = generateResourceModule(rb, outputs, opts.Environment)
+	}
+
+	files[MainModuleFile] = generateMainModule(workloads, workloadOutputs, resourcesBicep, opts)
+	return files, nil
+}
+
+// workloadModulePath returns the relative path of the module of a workload
+func workloadModulePath(workloadName string) string {
+	return path.Join(ModulesDirectory, workloadName+".bicep")
+}
+
+// resourceModulePath returns the relative path of the module of a provisioned resource
+func resourceModulePath(resUid framework.ResourceUid) string {
+	return path.Join(ResourceModulesDirectory, BicepSymbol(string(resUid))+".bicep")
+}
+
+// referencesSymbol returns true if the Bicep declarations use the given symbolic name
+func referencesSymbol(bicep string, symbol string) bool {
+	return slices.Contains(bicepWordRegex.FindAllString(bicep, -1), symbol)
+}
+
+// checkResourceReferences returns an error if the Bicep of a resource uses a symbol declared by another resource, since
+// each resource is declared in its own module and cannot see the symbols of the others
+func checkResourceReferences(resourcesBicep []ResourceBicep) error {
+	declaredBy := make(map[string]framework.ResourceUid)
+	for _, rb := range resourcesBicep {
+		for _, m := range bicepDeclarationRegex.FindAllStringSubmatch(rb.Bicep, -1) {
+			declaredBy[m[1]] = rb.Uid
+		}
+	}
+	for _, rb := range resourcesBicep {
+		for _, word := range bicepWordRegex.FindAllString(rb.Bicep, -1) {
+			if other, ok := declaredBy[word]; ok && other != rb.Uid {
+				return fmt.Errorf("resource '%s': the Bicep uses the symbol '%s' of resource '%s', which is declared in another module", rb.Uid, word, other)
+			}
+		}
+	}
+	return nil
+}
+
+// generateMainModule generates the main.bicep file that declares the container app environment and references the
+// module of each resource and workload. The modules are given the scope of an existing environment, and the params of
+// a resource without a default value are declared by the main module and passed on.
+func generateMainModule(workloads []bicepWorkload, workloadOutputs map[string][]moduleOutput, resourcesBicep []ResourceBicep, opts Options) string {
+	env := opts.Environment
+	w := new(bicepWriter)
+	w.sb.WriteString(generateBicepHeader())
+	w.sb.WriteString(generateBicepParameters(environmentName(workloads), workloads, opts))
+	w.sb.WriteString(generateContainerAppEnvironment(opts))
+
+	for _, rb := range resourcesBicep {
+		symbol := BicepSymbol(string(rb.Uid))
+		body := bicepObject{{"name", symbol}}
+		params := bicepObject{}
+		if referencesSymbol(rb.Bicep, "containerAppEnvironment") {
+			params = append(params, bicepProperty{"environmentName", bicepExpression("containerAppEnvironment.name")})
+			scopeParams := environmentScopeParams(env)
+			for _, k := range slices.Sorted(maps.Keys(scopeParams)) {
+				params = append(params, bicepProperty{k, scopeParams[k]})
+			}
+		}
+		if referencesSymbol(rb.Bicep, "location") {
+			params = append(params, bicepProperty{"location", bicepExpression("location")})
+		}
+		requiredParams := requiredParamRegex.FindAllStringSubmatch(rb.Bicep, -1)
+		for _, m := range requiredParams {
+			params = append(params, bicepProperty{m[2], bicepExpression(m[2])})
+		}
+		if len(params) > 0 {
+			body = append(body, bicepProperty{"params", params})
+		}
+		w.WriteLine("")
+		w.WriteLine(fmt.Sprintf("// Resource '%s'", rb.Uid))
+		for _, m := range requiredParams {
+			w.WriteLine(m[0])
+		}
+		w.WriteModule(symbol, resourceModulePath(rb.Uid), body)
+	}
+	w.sb.WriteString(generateRegistries(projectRegistries(workloads)))
+
+	for _, workload := range workloads {
+		params := environmentScopeParams(env)
+		params["containerAppName"] = bicepExpression(workload.AppNameParam)
+		params["environmentName"] = "containerAppEnvironment.name"
+		params["location"] = "location"
+		if len(workload.Registries) > 0 {
+			params["registryIdentityName"] = bicepExpression(registryIdentitySymbol + ".name")
+		}
+		for _, p := range workloadOutputs[workload.Name] {
+			params[p.Param] = bicepExpression(fmt.Sprintf("%s.outputs.%s", BicepSymbol(string(p.ResourceUid)), p.Name))
+		}
+		for _, p := range workload.ServiceParams {
+			params[p] = bicepExpression(p)
+		}
+		sortedParams := bicepObject{}
+		for _, k := range slices.Sorted(maps.Keys(params)) {
+			sortedParams = append(sortedParams, bicepProperty{k, params[k]})
+		}
+		w.WriteLine("")
+		w.WriteLine(fmt.Sprintf("// Container App '%s'", workload.Name))
+		body := bicepObject{
+			{"name", workload.Name},
+			{"params", sortedParams},
+		}
+		if dependsOn := registryDependencies(workload); len(dependsOn) > 0 {
+			body = append(body, bicepProperty{"dependsOn", dependsOn})
+		}
+		w.WriteModule(workload.AppSymbol, workloadModulePath(workload.Name), body)
+	}
+
+	hasOutputs := false
+	for _, workload := range workloads {
+		if workload.Spec.Service != nil && len(workload.Spec.Service.Ports) > 0 {
+			if !hasOutputs {
+				w.WriteLine("")
+				w.WriteLine("// Outputs")
+				hasOutputs = true
+			}
+			w.WriteOutput(workload.FQDNOutput, "string", bicepExpression(workload.AppSymbol+".outputs.containerAppFQDN"))
+		}
+	}
+	return w.String()
+}
+
+// generateWorkloadModule generates the module of a workload around its container app. The module references the
+// existing container app environment and declares a parameter for each resource output it uses.
+func generateWorkloadModule(workload bicepWorkload, containerApp string, params []moduleOutput) string {
+	w := new(bicepWriter)
+	w.sb.WriteString(fmt.Sprintf(bicepModuleHeader, fmt.Sprintf("workload '%s'", workload.Name)))
+	w.WriteLine("")
+	w.WriteLine("// Parameters")
+	w.WriteLine("param environmentName string")
+	writeEnvironmentScopeParams(w, workload.Options.Environment, false)
+	w.WriteParam("containerAppName", "string", DefaultContainerAppName(workload.Name))
+	w.WriteParam("location", "string", bicepExpression("resourceGroup().location"))
+	if len(workload.Registries) > 0 {
+		w.WriteLine("param registryIdentityName string")
+	}
+	for _, p := range params {
+		if p.Secret {
+			w.WriteLine("@secure()")
+		}
+		w.WriteLine(fmt.Sprintf("param %s string", p.Param))
+	}
+	for _, p := range workload.ServiceParams {
+		if p != "containerAppName" {
+			w.WriteLine(fmt.Sprintf("param %s string", p))
+		}
+	}
+	w.WriteLine("")
+	w.sb.WriteString(generateExistingContainerAppEnvironment(workload.Options.Environment))
+	if len(workload.Registries) > 0 {
+		w.WriteLine("")
+		w.WriteLine(fmt.Sprintf("resource %s 'Microsoft.ManagedIdentity/userAssignedIdentities@2023-01-31' existing = {", registryIdentitySymbol))
+		w.WriteLine("  name: registryIdentityName")
+		w.WriteLine("}")
+	}
+	w.sb.WriteString(generateExistingRoleAssignmentScopes(workload.RoleAssignments))
+	w.sb.WriteString(containerApp)
+	w.sb.WriteString(generateBicepOutputs([]bicepWorkload{workload}))
+	return w.String()
+}
+
+// generateResourceModule generates the module of a provisioned resource with an output for each Bicep expression used
+// by the workloads
+func generateResourceModule(rb ResourceBicep, outputs []moduleOutput, env *ExistingEnvironment) string {
+	w := new(bicepWriter)
+	w.sb.WriteString(fmt.Sprintf(bicepModuleHeader, fmt.Sprintf("resource '%s'", rb.Uid)))
+	usesLocation, usesEnvironment := referencesSymbol(rb.Bicep, "location"), referencesSymbol(rb.Bicep, "containerAppEnvironment")
+	if usesLocation || usesEnvironment {
+		w.WriteLine("")
+		w.WriteLine("// Parameters")
+		if usesEnvironment {
+			w.WriteLine("param environmentName string")
+			writeEnvironmentScopeParams(w, env, false)
+		}
+		if usesLocation {
+			w.WriteParam("location", "string", bicepExpression("resourceGroup().location"))
+		}
+	}
+	if usesEnvironment {
+		w.WriteLine("")
+		w.sb.WriteString(generateExistingContainerAppEnvironment(env))
+	}
+	w.sb.WriteString(generateResources([]ResourceBicep{rb}))
+	if len(outputs) > 0 {
+		w.WriteLine("")
+		w.WriteLine("// Outputs")
+		for _, o := range outputs {
+			if o.Secret {
+				w.WriteLine("@secure()")
+			}
+			w.WriteOutput(o.Name, "string", markInterpolations(o.Value))
+		}
+	}
+	return w.String()
+}
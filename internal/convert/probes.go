@@ -0,0 +1,180 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	scoretypes "github.com/score-spec/score-go/types"
+	"gopkg.in/yaml.v3"
+)
+
+// probeTypes maps the probe names used in the probes annotation to the Azure Container App probe types
+var probeTypes = map[string]string{
+	"liveness":  "Liveness",
+	"readiness": "Readiness",
+	"startup":   "Startup",
+}
+
+// probeAnnotation is the configuration of a probe of a container in the probes annotation. It overrides the timings of
+// the probe, and an httpGet or tcpSocket replaces the probe of the Score container.
+type probeAnnotation struct {
+	InitialDelaySeconds *int `yaml:"initialDelaySeconds"`
+	PeriodSeconds       *int `yaml:"periodSeconds"`
+	FailureThreshold    *int `yaml:"failureThreshold"`
+	SuccessThreshold    *int `yaml:"successThreshold"`
+	TimeoutSeconds      *int `yaml:"timeoutSeconds"`
+	HTTPGet             *struct {
+		Path        string `yaml:"path"`
+		Port        int    `yaml:"port"`
+		Host        string `yaml:"host"`
+		Scheme      string `yaml:"scheme"`
+		HTTPHeaders []struct {
+			Name  string `yaml:"name"`
+			Value string `yaml:"value"`
+		} `yaml:"httpHeaders"`
+	} `yaml:"httpGet"`
+	TCPSocket *struct {
+		Host string `yaml:"host"`
+		Port int    `yaml:"port"`
+	} `yaml:"tcpSocket"`
+}
+
+// probesAnnotation returns the probes annotation of a workload by container name and probe name
+func probesAnnotation(spec scoretypes.Workload) (map[string]map[string]probeAnnotation, error) {
+	v, ok := workloadAnnotation(spec, ProbesAnnotation)
+	if !ok {
+		return nil, nil
+	}
+	var out map[string]map[string]probeAnnotation
+	if err := yaml.Unmarshal([]byte(v), &out); err != nil {
+		return nil, fmt.Errorf("annotation '%s': failed to decode YAML map of probes by container: %w", ProbesAnnotation, err)
+	}
+	for _, containerName := range slices.Sorted(maps.Keys(out)) {
+		if _, ok := spec.Containers[containerName]; !ok {
+			return nil, fmt.Errorf("annotation '%s': '%s' is not a container of the workload", ProbesAnnotation, containerName)
+		}
+		for _, probeName := range slices.Sorted(maps.Keys(out[containerName])) {
+			if _, ok := probeTypes[probeName]; !ok {
+				return nil, fmt.Errorf("annotation '%s': %s: '%s' is not a probe, expected one of liveness, readiness, or startup", ProbesAnnotation, containerName, probeName)
+			}
+		}
+	}
+	return out, nil
+}
+
+// convertProbes converts the probes of a Score container to Azure Container App probes, with the configuration of the
+// probes annotation applied to them. Score exec probes are not supported by Azure Container Apps: they must be replaced
+// by an httpGet or tcpSocket probe in the annotation, otherwise they are skipped with a warning, or rejected in strict
+// mode.
+func convertProbes(containerName string, container scoretypes.Container, annotations map[string]probeAnnotation, strict bool) ([]ContainerAppProbe, error) {
+	var probes []ContainerAppProbe
+	for _, probeName := range []string{"liveness", "readiness", "startup"} {
+		var scoreProbe *scoretypes.ContainerProbe
+		switch probeName {
+		case "liveness":
+			scoreProbe = container.LivenessProbe
+		case "readiness":
+			scoreProbe = container.ReadinessProbe
+		}
+		annotation, hasAnnotation := annotations[probeName]
+
+		probe := ContainerAppProbe{
+			Type:                probeTypes[probeName],
+			InitialDelaySeconds: 15,
+			PeriodSeconds:       30,
+			FailureThreshold:    3,
+			TimeoutSeconds:      1,
+		}
+		switch {
+		case annotation.HTTPGet != nil && annotation.TCPSocket != nil:
+			return nil, fmt.Errorf("annotation '%s': %s: httpGet and tcpSocket cannot be combined", ProbesAnnotation, probeName)
+		case annotation.HTTPGet != nil:
+			probe.HTTPGet = &ContainerAppHTTPGet{
+				Path:   annotation.HTTPGet.Path,
+				Port:   annotation.HTTPGet.Port,
+				Host:   annotation.HTTPGet.Host,
+				Scheme: strings.ToUpper(annotation.HTTPGet.Scheme),
+			}
+			for _, h := range annotation.HTTPGet.HTTPHeaders {
+				probe.HTTPGet.HTTPHeaders = append(probe.HTTPGet.HTTPHeaders, ContainerAppHTTPHeader{Name: h.Name, Value: h.Value})
+			}
+		case annotation.TCPSocket != nil:
+			probe.TCPSocket = &ContainerAppTCPSocket{Host: annotation.TCPSocket.Host, Port: annotation.TCPSocket.Port}
+		case scoreProbe != nil && scoreProbe.HttpGet != nil:
+			probe.HTTPGet = convertHTTPProbe(scoreProbe.HttpGet)
+		case scoreProbe != nil && scoreProbe.Exec != nil:
+			message := fmt.Sprintf("%sProbe: exec probes are not supported by Azure Container Apps, replace it with an httpGet or tcpSocket probe in the '%s' annotation", probeName, ProbesAnnotation)
+			if strict {
+				return nil, fmt.Errorf("%s", message)
+			}
+			slog.Warn(fmt.Sprintf("%s: %s. The probe is skipped.", containerName, message))
+			continue
+		case hasAnnotation:
+			return nil, fmt.Errorf("annotation '%s': %s: the container has no %s probe, set an httpGet or tcpSocket probe", ProbesAnnotation, probeName, probeName)
+		default:
+			continue
+		}
+
+		if probe.HTTPGet != nil && (probe.HTTPGet.Port < 1 || probe.HTTPGet.Port > 65535) {
+			return nil, fmt.Errorf("%sProbe: httpGet: port %d must be between 1 and 65535", probeName, probe.HTTPGet.Port)
+		} else if probe.TCPSocket != nil && (probe.TCPSocket.Port < 1 || probe.TCPSocket.Port > 65535) {
+			return nil, fmt.Errorf("%sProbe: tcpSocket: port %d must be between 1 and 65535", probeName, probe.TCPSocket.Port)
+		}
+		for _, timing := range []struct {
+			name     string
+			value    *int
+			target   *int
+			min, max int
+		}{
+			{"initialDelaySeconds", annotation.InitialDelaySeconds, &probe.InitialDelaySeconds, 0, 60},
+			{"periodSeconds", annotation.PeriodSeconds, &probe.PeriodSeconds, 1, 240},
+			{"failureThreshold", annotation.FailureThreshold, &probe.FailureThreshold, 1, 10},
+			{"successThreshold", annotation.SuccessThreshold, &probe.SuccessThreshold, 1, 10},
+			{"timeoutSeconds", annotation.TimeoutSeconds, &probe.TimeoutSeconds, 1, 240},
+		} {
+			if timing.value == nil {
+				continue
+			} else if *timing.value < timing.min || *timing.value > timing.max {
+				return nil, fmt.Errorf("annotation '%s': %s: %s %d must be between %d and %d", ProbesAnnotation, probeName, timing.name, *timing.value, timing.min, timing.max)
+			}
+			*timing.target = *timing.value
+		}
+		probes = append(probes, probe)
+	}
+	return probes, nil
+}
+
+// convertHTTPProbe converts a Score http probe to the httpGet of an Azure Container App probe
+func convertHTTPProbe(probe *scoretypes.HttpProbe) *ContainerAppHTTPGet {
+	out := &ContainerAppHTTPGet{
+		Path: probe.Path,
+		Port: probe.Port,
+	}
+	if probe.Host != nil {
+		out.Host = *probe.Host
+	}
+	if probe.Scheme != nil {
+		out.Scheme = string(*probe.Scheme)
+	}
+	for _, h := range probe.HttpHeaders {
+		out.HTTPHeaders = append(out.HTTPHeaders, ContainerAppHTTPHeader{Name: h.Name, Value: h.Value})
+	}
+	return out
+}
@@ -0,0 +1,206 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/score-spec/score-go/framework"
+	scoretypes "github.com/score-spec/score-go/types"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// registryIdentitySymbol is the symbolic name of the user-assigned identity that pulls the images of Azure
+	// Container Registries
+	registryIdentitySymbol = "registryIdentity"
+	// acrPullRoleDefinitionID is the id of the built-in AcrPull role
+	acrPullRoleDefinitionID = "7f951dda-4ed3-4680-a7ca-43fe172d538d"
+)
+
+// acrServerRegex matches the login server of an Azure Container Registry and captures the registry name
+var acrServerRegex = regexp.MustCompile(`^([a-zA-Z0-9]+)\.azurecr\.io$`)
+
+// registryAnnotation is a registry of the registries annotation. The images are pulled with the managed identity, or
+// with the username and password, which may contain placeholders. Azure Container Registries may set neither, they
+// then use the shared registry identity.
+type registryAnnotation struct {
+	Server   string `yaml:"server"`
+	Identity string `yaml:"identity"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// imageRegistry returns the host of the registry of a container image, or an empty string for Docker Hub images. Like
+// Docker, the first path segment is a host if it contains a '.' or ':' or is 'localhost'.
+func imageRegistry(image string) string {
+	host, rest, hasPath := strings.Cut(image, "/")
+	if !hasPath || rest == "" {
+		return ""
+	} else if strings.ContainsAny(host, ".:") || host == "localhost" {
+		return host
+	}
+	return ""
+}
+
+// workloadRegistries returns the registries of a workload sorted by server: the ones of the registries annotation,
+// and the Azure Container Registries of the container images that are not in the annotation
+func workloadRegistries(spec scoretypes.Workload) ([]registryAnnotation, error) {
+	registries := make(map[string]registryAnnotation)
+	if v, ok := workloadAnnotation(spec, RegistriesAnnotation); ok {
+		var entries []registryAnnotation
+		if err := yaml.Unmarshal([]byte(v), &entries); err != nil {
+			return nil, fmt.Errorf("annotation '%s': failed to decode YAML list of registries: %w", RegistriesAnnotation, err)
+		}
+		for i, r := range entries {
+			switch {
+			case r.Server == "":
+				return nil, fmt.Errorf("annotation '%s': registries[%d]: missing server", RegistriesAnnotation, i)
+			case registries[r.Server] != (registryAnnotation{}):
+				return nil, fmt.Errorf("annotation '%s': registries[%d]: server '%s' is declared more than once", RegistriesAnnotation, i, r.Server)
+			case r.Identity != "" && (r.Username != "" || r.Password != ""):
+				return nil, fmt.Errorf("annotation '%s': registries[%d]: an identity cannot be combined with a username and password", RegistriesAnnotation, i)
+			case (r.Username == "") != (r.Password == ""):
+				return nil, fmt.Errorf("annotation '%s': registries[%d]: a username needs a password", RegistriesAnnotation, i)
+			case r.Identity == "" && r.Username == "" && !acrServerRegex.MatchString(r.Server):
+				return nil, fmt.Errorf("annotation '%s': registries[%d]: '%s' is not an Azure Container Registry, set an identity or a username and password", RegistriesAnnotation, i, r.Server)
+			}
+			registries[r.Server] = r
+		}
+	}
+	for _, container := range spec.Containers {
+		if server := imageRegistry(container.Image); acrServerRegex.MatchString(server) {
+			if _, ok := registries[server]; !ok {
+				registries[server] = registryAnnotation{Server: server}
+			}
+		}
+	}
+	out := make([]registryAnnotation, 0, len(registries))
+	for _, server := range slices.Sorted(maps.Keys(registries)) {
+		out = append(out, registries[server])
+	}
+	return out, nil
+}
+
+// convertRegistries converts the registries of a workload to the registries of the container app configuration, with
+// the secrets holding their passwords. Azure Container Registries without identity or username use the shared registry
+// identity.
+func convertRegistries(spec scoretypes.Workload, resOutputs map[string]framework.OutputLookupFunc, keyVaultIdentity string) ([]ContainerAppRegistry, []ContainerAppSecret, error) {
+	registries, err := workloadRegistries(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	var out []ContainerAppRegistry
+	var secrets []ContainerAppSecret
+	sf := framework.BuildSubstitutionFunction(spec.Metadata, resOutputs)
+	for _, r := range registries {
+		registry := ContainerAppRegistry{Server: r.Server, Identity: r.Identity}
+		switch {
+		case r.Username != "":
+			username, err := framework.SubstituteString(r.Username, sf)
+			if err != nil {
+				return nil, nil, fmt.Errorf("annotation '%s': %s: username: %w", RegistriesAnnotation, r.Server, err)
+			} else if _, isSecret := unmarkSecrets(username); isSecret {
+				return nil, nil, fmt.Errorf("annotation '%s': %s: username: secret outputs can only be used in the password", RegistriesAnnotation, r.Server)
+			}
+			password, err := framework.SubstituteString(r.Password, sf)
+			if err != nil {
+				return nil, nil, fmt.Errorf("annotation '%s': %s: password: %w", RegistriesAnnotation, r.Server, err)
+			}
+			secret, err := convertSecretVariable(volumeName("registry", r.Server), password, keyVaultIdentity)
+			if err != nil {
+				return nil, nil, fmt.Errorf("annotation '%s': %s: password: %w", RegistriesAnnotation, r.Server, err)
+			}
+			secrets = append(secrets, secret)
+			registry.Username, registry.PasswordSecretRef = username, secret.Name
+		case r.Identity == "":
+			registry.Identity = markInterpolations("${" + registryIdentitySymbol + ".id}")
+		}
+		out = append(out, registry)
+	}
+	return out, secrets, nil
+}
+
+// sharedIdentityRegistries returns the names of the Azure Container Registries that a workload pulls from with the
+// shared registry identity
+func sharedIdentityRegistries(spec scoretypes.Workload) ([]string, error) {
+	registries, err := workloadRegistries(spec)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, r := range registries {
+		if r.Identity == "" && r.Username == "" {
+			names = append(names, acrServerRegex.FindStringSubmatch(r.Server)[1])
+		}
+	}
+	return names, nil
+}
+
+// projectRegistries returns the names of the Azure Container Registries pulled with the shared registry identity by
+// any of the workloads, sorted and without duplicates
+func projectRegistries(workloads []bicepWorkload) []string {
+	names := make(map[string]bool)
+	for _, workload := range workloads {
+		for _, name := range workload.Registries {
+			names[name] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(names))
+}
+
+// registryDependencies returns the role assignments that must be created before the container app of a workload can
+// pull its images
+func registryDependencies(workload bicepWorkload) bicepArray {
+	out := bicepArray{}
+	for _, name := range workload.Registries {
+		out = append(out, bicepExpression(acrPullSymbol(name)))
+	}
+	return out
+}
+
+// acrPullSymbol returns the symbolic name of the AcrPull role assignment of the shared identity on a registry
+func acrPullSymbol(registryName string) string {
+	return BicepSymbol("registry", registryName, "acrPull")
+}
+
+// generateRegistries generates the shared registry identity and its AcrPull role assignment on each of the Azure
+// Container Registries, which must be in the resource group of the deployment
+func generateRegistries(registryNames []string) string {
+	if len(registryNames) == 0 {
+		return ""
+	}
+	w := new(bicepWriter)
+	w.WriteLine("")
+	w.WriteLine("// Container Registries")
+	w.WriteResource(registryIdentitySymbol, "Microsoft.ManagedIdentity/userAssignedIdentities@2023-01-31", bicepObject{
+		{"name", bicepExpression("registryIdentityName")},
+		{"location", bicepExpression("location")},
+	})
+	for _, name := range registryNames {
+		symbol := BicepSymbol("registry", name)
+		w.WriteLine("")
+		w.WriteLine(fmt.Sprintf("resource %s 'Microsoft.ContainerRegistry/registries@2023-07-01' existing = {", symbol))
+		w.WriteLine(fmt.Sprintf("  name: %s", bicepString(name)))
+		w.WriteLine("}")
+		w.WriteLine("")
+		writeRoleAssignment(w, acrPullSymbol(name), symbol, registryIdentitySymbol, registryIdentitySymbol+".properties.principalId", acrPullRoleDefinitionID)
+	}
+	return w.String()
+}
@@ -0,0 +1,244 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	scoretypes "github.com/score-spec/score-go/types"
+)
+
+// memoryRegex matches a Kubernetes style memory quantity such as '512Mi', '1G', or '0.5Gi'
+var memoryRegex = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)(Ki|Mi|Gi|Ti|k|K|M|G|T)?$`)
+
+// memoryUnits holds the number of bytes of each memory unit
+var memoryUnits = map[string]float64{
+	"":   1,
+	"k":  1e3,
+	"K":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+}
+
+// parseMemory parses a memory quantity to a number of Gi
+func parseMemory(memory string) (float64, error) {
+	matches := memoryRegex.FindStringSubmatch(strings.TrimSpace(memory))
+	if matches == nil {
+		return 0, fmt.Errorf("'%s' is not a valid memory quantity, expected a number with an optional unit like 512Mi or 1Gi", memory)
+	}
+	value, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a valid memory quantity: %w", memory, err)
+	}
+	return value * memoryUnits[matches[2]] / (1 << 30), nil
+}
+
+// formatMemory formats a number of Gi as a memory quantity accepted by Azure Container Apps, rounded up to a
+// thousandth of a Gi
+func formatMemory(gi float64) string {
+	return strconv.FormatFloat(math.Ceil(math.Round(gi*1e6)/1e3)/1e3, 'f', -1, 64) + "Gi"
+}
+
+// LimitsPolicy selects how the resource limits of the Score containers are mapped onto the cpu and memory of the
+// containers, since Azure Container Apps only supports a single value for each of them
+type LimitsPolicy string
+
+const (
+	// LimitsPolicyFallback uses the limits when no requests are set
+	LimitsPolicyFallback LimitsPolicy = "fallback"
+	// LimitsPolicyMax uses the maximum of the requests and the limits
+	LimitsPolicyMax LimitsPolicy = "max"
+	// LimitsPolicyError fails the conversion when limits are set
+	LimitsPolicyError LimitsPolicy = "error"
+)
+
+// LimitsPolicies lists the supported limits policies, the first one is the default
+var LimitsPolicies = []LimitsPolicy{LimitsPolicyFallback, LimitsPolicyMax, LimitsPolicyError}
+
+// quantityExamples describes the expected format of each kind of resource quantity
+var quantityExamples = map[string]string{
+	"cpu":    "a number of cores like 0.5 or millicores like 500m",
+	"memory": "a number with an optional unit like 512Mi or 1Gi",
+}
+
+// parseQuantity parses a cpu quantity to a number of cores or a memory quantity to a number of Gi
+func parseQuantity(kind string, quantity string) (float64, error) {
+	parse := parseCPU
+	if kind == "memory" {
+		parse = parseMemory
+	}
+	value, err := parse(quantity)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("'%s' is not a valid %s quantity, expected %s", quantity, kind, quantityExamples[kind])
+	}
+	return value, nil
+}
+
+// resolveQuantity returns the value of a kind of resource from the request and limit of a container with the limits
+// policy, or false if neither is set
+func resolveQuantity(containerName string, kind string, request *string, limit *string, policy LimitsPolicy) (float64, bool, error) {
+	var requestValue, limitValue float64
+	var err error
+	if request != nil {
+		if requestValue, err = parseQuantity(kind, *request); err != nil {
+			return 0, false, fmt.Errorf("requests: %s: %w", kind, err)
+		}
+	}
+	if limit != nil {
+		if policy == LimitsPolicyError {
+			return 0, false, fmt.Errorf("limits: %s: resource limits are not supported with the '%s' limits policy, set the wanted value in the requests section", kind, policy)
+		}
+		if limitValue, err = parseQuantity(kind, *limit); err != nil {
+			return 0, false, fmt.Errorf("limits: %s: %w", kind, err)
+		}
+	}
+
+	switch {
+	case limit == nil:
+		return requestValue, request != nil, nil
+	case request == nil:
+		slog.Info(fmt.Sprintf("%s: Using the %s limit '%s' since no request is set.", containerName, kind, *limit))
+		return limitValue, true, nil
+	case policy == LimitsPolicyMax && limitValue > requestValue:
+		slog.Info(fmt.Sprintf("%s: Using the %s limit '%s' since it is above the request '%s'.", containerName, kind, *limit, *request))
+		return limitValue, true, nil
+	default:
+		slog.Info(fmt.Sprintf("%s: Ignoring the %s limit '%s' in favour of the request '%s'.", containerName, kind, *limit, *request))
+		return requestValue, true, nil
+	}
+}
+
+// convertContainerResources returns the cpu and memory of a container from its requests and limits with the limits
+// policy, the defaults of 0.25 cores and 0.5Gi are used for the values that are not set
+func convertContainerResources(containerName string, resources *scoretypes.ContainerResources, policy LimitsPolicy) (ContainerAppResources, error) {
+	out := ContainerAppResources{CPU: 0.25, Memory: "0.5Gi"}
+	if resources == nil {
+		return out, nil
+	}
+	requests, limits := resources.Requests, resources.Limits
+	if requests == nil {
+		requests = &scoretypes.ResourcesLimits{}
+	}
+	if limits == nil {
+		limits = &scoretypes.ResourcesLimits{}
+	}
+
+	if cpu, ok, err := resolveQuantity(containerName, "cpu", requests.Cpu, limits.Cpu, policy); err != nil {
+		return out, err
+	} else if ok {
+		out.CPU = cpu
+	}
+	if memory, ok, err := resolveQuantity(containerName, "memory", requests.Memory, limits.Memory, policy); err != nil {
+		return out, err
+	} else if ok {
+		out.Memory = formatMemory(memory)
+	}
+	return out, nil
+}
+
+// workloadProfile holds the total cpu and memory available to a container app on a workload profile type
+type workloadProfile struct {
+	CPU    float64
+	Memory float64
+}
+
+// ConsumptionWorkloadProfile is the workload profile type of the serverless consumption plan
+const ConsumptionWorkloadProfile = "Consumption"
+
+// dedicatedWorkloadProfiles holds the cpu and memory of the dedicated workload profile types
+var dedicatedWorkloadProfiles = map[string]workloadProfile{
+	"D4":         {4, 16},
+	"D8":         {8, 32},
+	"D16":        {16, 64},
+	"D32":        {32, 128},
+	"E4":         {4, 32},
+	"E8":         {8, 64},
+	"E16":        {16, 128},
+	"E32":        {32, 256},
+	"NC24-A100":  {24, 220},
+	"NC48-A100":  {48, 440},
+	"NC96-A100":  {96, 880},
+	"NC8as-T4":   {8, 56},
+	"NC16as-T4":  {16, 110},
+	"NC64as-T4":  {64, 440},
+	"NV6ads-A10": {6, 55},
+}
+
+// consumptionCPUStep is the cpu increment of the allowed consumption combinations, each one pairs the cpu with twice
+// as many Gi of memory from 0.25 vCPU and 0.5Gi up to 4 vCPU and 8Gi
+const (
+	consumptionCPUStep = 0.25
+	consumptionMaxCPU  = 4
+)
+
+// validateResources checks that the total cpu and memory of the containers of an app are allowed by the workload
+// profile type. On the consumption plan, a total that is not one of the allowed combinations is rounded up to the
+// nearest one by raising the resources of the first container, and a warning is logged. In strict mode an error is
+// returned instead.
+func validateResources(workloadName string, properties *ContainerAppProperties, profileType string, strict bool) error {
+	if len(properties.Template.Containers) == 0 {
+		return nil
+	}
+	var totalCPU, totalMemory float64
+	for _, c := range properties.Template.Containers {
+		memory, err := parseMemory(c.Resources.Memory)
+		if err != nil {
+			return fmt.Errorf("containers: %s: resources: memory: %w", c.Name, err)
+		}
+		totalCPU += c.Resources.CPU
+		totalMemory += memory
+	}
+	// tolerate floating point errors when summing the containers
+	totalCPU, totalMemory = math.Round(totalCPU*1e6)/1e6, math.Round(totalMemory*1e6)/1e6
+
+	if profileType != ConsumptionWorkloadProfile {
+		profile, ok := dedicatedWorkloadProfiles[profileType]
+		if !ok {
+			slog.Warn(fmt.Sprintf("%s: Workload profile type '%s' is unknown, the cpu and memory of the container app are not validated.", workloadName, profileType))
+		} else if totalCPU > profile.CPU || totalMemory > profile.Memory {
+			return fmt.Errorf("containers request %v vCPU and %s in total, which exceeds the %v vCPU and %s of the '%s' workload profile", totalCPU, formatMemory(totalMemory), profile.CPU, formatMemory(profile.Memory), profileType)
+		}
+		return nil
+	}
+
+	steps := math.Ceil(math.Max(totalCPU/consumptionCPUStep, totalMemory/(2*consumptionCPUStep)) - 1e-9)
+	validCPU, validMemory := steps*consumptionCPUStep, steps*2*consumptionCPUStep
+	if validCPU > consumptionMaxCPU {
+		return fmt.Errorf("containers request %v vCPU and %s in total, which exceeds the maximum of %v vCPU and %s of the consumption plan", totalCPU, formatMemory(totalMemory), consumptionMaxCPU, formatMemory(2*consumptionMaxCPU))
+	}
+	if validCPU == totalCPU && validMemory == totalMemory {
+		return nil
+	}
+	if strict {
+		return fmt.Errorf("containers request %v vCPU and %s in total, which is not an allowed combination of the consumption plan, the nearest one is %v vCPU and %s", totalCPU, formatMemory(totalMemory), validCPU, formatMemory(validMemory))
+	}
+	first := &properties.Template.Containers[0]
+	firstMemory, _ := parseMemory(first.Resources.Memory)
+	first.Resources.CPU = math.Round((first.Resources.CPU+validCPU-totalCPU)*1e6) / 1e6
+	first.Resources.Memory = formatMemory(firstMemory + validMemory - totalMemory)
+	slog.Warn(fmt.Sprintf("%s: Containers request %v vCPU and %s in total, which is not an allowed combination of the consumption plan. Rounded up to %v vCPU and %s by raising the resources of container '%s' to %v vCPU and %s.",
+		workloadName, totalCPU, formatMemory(totalMemory), validCPU, formatMemory(validMemory), first.Name, first.Resources.CPU, first.Resources.Memory))
+	return nil
+}
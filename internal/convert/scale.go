@@ -0,0 +1,216 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/score-spec/score-go/framework"
+	scoretypes "github.com/score-spec/score-go/types"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// MaxReplicas is the maximum number of replicas of an Azure Container App
+	MaxReplicas = 300
+	// DefaultMaxReplicas is the maximum number of replicas of an Azure Container App that sets no maximum
+	DefaultMaxReplicas = 10
+	// DefaultMaxExecutions is the maximum number of executions of an event job that sets no maximum
+	DefaultMaxExecutions = 100
+)
+
+// scaleRuleNameRegex matches the valid names of scale rules
+var scaleRuleNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// scaleRuleAnnotation is a custom KEDA scale rule in the scale rules annotation
+type scaleRuleAnnotation struct {
+	Name     string                 `yaml:"name"`
+	Type     string                 `yaml:"type"`
+	Metadata map[string]interface{} `yaml:"metadata"`
+	Auth     []struct {
+		TriggerParameter string `yaml:"triggerParameter"`
+		SecretRef        string `yaml:"secretRef"`
+		Value            string `yaml:"value"`
+	} `yaml:"auth"`
+}
+
+// convertScale converts the scale annotations of a workload to the scale block of the container app template, or
+// returns nil if none is set. The 'http' type of a custom rule is converted to an http rule, any other type is a KEDA
+// scaler. Metadata values may contain placeholders. An auth entry references a secret of the container app by
+// 'secretRef', which the caller validates once all secrets are known, or sets a 'value' that may contain placeholders
+// and is stored in a new secret which is returned too.
+func convertScale(spec scoretypes.Workload, resOutputs map[string]framework.OutputLookupFunc, keyVaultIdentity string) (*ContainerAppScale, []ContainerAppSecret, error) {
+	scale := &ContainerAppScale{}
+	isSet := false
+	if v, ok, err := intAnnotation(spec, MinReplicasAnnotation, 0, MaxReplicas); err != nil {
+		return nil, nil, err
+	} else if ok {
+		scale.MinReplicas, isSet = &v, true
+	}
+	if v, ok, err := intAnnotation(spec, MaxReplicasAnnotation, 1, MaxReplicas); err != nil {
+		return nil, nil, err
+	} else if ok {
+		scale.MaxReplicas, isSet = v, true
+	}
+	if scale.MinReplicas != nil && scale.MaxReplicas != 0 && *scale.MinReplicas > scale.MaxReplicas {
+		return nil, nil, fmt.Errorf("annotation '%s': %d must not be greater than the maximum of %d replicas", MinReplicasAnnotation, *scale.MinReplicas, scale.MaxReplicas)
+	} else if scale.MinReplicas != nil && scale.MaxReplicas == 0 {
+		defaultMax := DefaultMaxReplicas
+		if _, isJob := workloadAnnotation(spec, JobTriggerAnnotation); isJob {
+			defaultMax = DefaultMaxExecutions
+		}
+		if *scale.MinReplicas > defaultMax {
+			return nil, nil, fmt.Errorf("annotation '%s': %d must not be greater than the default maximum of %d replicas, set the '%s' annotation too", MinReplicasAnnotation, *scale.MinReplicas, defaultMax, MaxReplicasAnnotation)
+		}
+	}
+
+	if v, ok, err := intAnnotation(spec, ScaleHTTPConcurrencyAnnotation, 1, 1000000); err != nil {
+		return nil, nil, err
+	} else if ok {
+		scale.Rules = append(scale.Rules, ContainerAppScaleRule{Name: "http", HTTP: &ContainerAppScaleRuleTrigger{
+			Metadata: map[string]string{"concurrentRequests": strconv.Itoa(v)},
+		}})
+	}
+	for _, resource := range []struct{ name, annotation string }{{"cpu", ScaleCPUUtilizationAnnotation}, {"memory", ScaleMemoryUtilizationAnnotation}} {
+		if v, ok, err := intAnnotation(spec, resource.annotation, 1, 100); err != nil {
+			return nil, nil, err
+		} else if ok {
+			scale.Rules = append(scale.Rules, ContainerAppScaleRule{Name: resource.name, Custom: &ContainerAppScaleRuleTrigger{
+				Type:     resource.name,
+				Metadata: map[string]string{"type": "Utilization", "value": strconv.Itoa(v)},
+			}})
+		}
+	}
+
+	var secrets []ContainerAppSecret
+	if v, ok := workloadAnnotation(spec, ScaleRulesAnnotation); ok {
+		var rules []scaleRuleAnnotation
+		if err := yaml.Unmarshal([]byte(v), &rules); err != nil {
+			return nil, nil, fmt.Errorf("annotation '%s': failed to decode YAML list of rules: %w", ScaleRulesAnnotation, err)
+		}
+		sf := framework.BuildSubstitutionFunction(spec.Metadata, resOutputs)
+		for i, r := range rules {
+			if !scaleRuleNameRegex.MatchString(r.Name) {
+				return nil, nil, fmt.Errorf("annotation '%s': rules[%d]: name '%s' must consist of lower case alphanumeric characters or '-'", ScaleRulesAnnotation, i, r.Name)
+			} else if r.Type == "" {
+				return nil, nil, fmt.Errorf("annotation '%s': rules[%d]: missing type", ScaleRulesAnnotation, i)
+			}
+			trigger := &ContainerAppScaleRuleTrigger{Metadata: make(map[string]string, len(r.Metadata))}
+			for k, mv := range r.Metadata {
+				value, err := framework.SubstituteString(fmt.Sprint(mv), sf)
+				if err != nil {
+					return nil, nil, fmt.Errorf("annotation '%s': rules[%d]: metadata: %s: %w", ScaleRulesAnnotation, i, k, err)
+				} else if _, isSecret := unmarkSecrets(value); isSecret {
+					return nil, nil, fmt.Errorf("annotation '%s': rules[%d]: metadata: %s: secret outputs must be passed through auth", ScaleRulesAnnotation, i, k)
+				}
+				trigger.Metadata[k] = value
+			}
+			for j, a := range r.Auth {
+				if a.TriggerParameter == "" {
+					return nil, nil, fmt.Errorf("annotation '%s': rules[%d]: auth[%d]: missing triggerParameter", ScaleRulesAnnotation, i, j)
+				}
+				auth := ContainerAppScaleRuleAuth{SecretRef: a.SecretRef, TriggerParameter: a.TriggerParameter}
+				if (a.SecretRef == "") == (a.Value == "") {
+					return nil, nil, fmt.Errorf("annotation '%s': rules[%d]: auth[%d]: exactly one of secretRef or value must be set", ScaleRulesAnnotation, i, j)
+				} else if a.Value != "" {
+					value, err := framework.SubstituteString(a.Value, sf)
+					if err != nil {
+						return nil, nil, fmt.Errorf("annotation '%s': rules[%d]: auth[%d]: %w", ScaleRulesAnnotation, i, j, err)
+					}
+					secret, err := convertSecretVariable(volumeName("scale-"+r.Name, a.TriggerParameter), value, keyVaultIdentity)
+					if err != nil {
+						return nil, nil, fmt.Errorf("annotation '%s': rules[%d]: auth[%d]: %w", ScaleRulesAnnotation, i, j, err)
+					}
+					secrets = append(secrets, secret)
+					auth.SecretRef = secret.Name
+				}
+				trigger.Auth = append(trigger.Auth, auth)
+			}
+			rule := ContainerAppScaleRule{Name: r.Name}
+			if r.Type == "http" {
+				rule.HTTP = trigger
+			} else {
+				trigger.Type = r.Type
+				rule.Custom = trigger
+			}
+			scale.Rules = append(scale.Rules, rule)
+		}
+	}
+
+	seenRules := make(map[string]bool, len(scale.Rules))
+	for _, r := range scale.Rules {
+		if seenRules[r.Name] {
+			return nil, nil, fmt.Errorf("scale rule '%s' is declared more than once", r.Name)
+		}
+		seenRules[r.Name] = true
+	}
+	if !isSet && len(scale.Rules) == 0 {
+		return nil, nil, nil
+	}
+	return scale, secrets, nil
+}
+
+// generateScale generates the scale block of the container app template
+func generateScale(scale ContainerAppScale) bicepObject {
+	out := bicepObject{}
+	if scale.MinReplicas != nil {
+		out = append(out, bicepProperty{"minReplicas", *scale.MinReplicas})
+	}
+	if scale.MaxReplicas != 0 {
+		out = append(out, bicepProperty{"maxReplicas", scale.MaxReplicas})
+	}
+	if len(scale.Rules) > 0 {
+		rules := bicepArray{}
+		for _, r := range scale.Rules {
+			rule := bicepObject{{"name", r.Name}}
+			if r.HTTP != nil {
+				rule = append(rule, bicepProperty{"http", generateScaleRuleTrigger(*r.HTTP)})
+			}
+			if r.Custom != nil {
+				rule = append(rule, bicepProperty{"custom", generateScaleRuleTrigger(*r.Custom)})
+			}
+			rules = append(rules, rule)
+		}
+		out = append(out, bicepProperty{"rules", rules})
+	}
+	return out
+}
+
+// generateScaleRuleTrigger generates the trigger of a scale rule, the metadata keys are sorted
+func generateScaleRuleTrigger(trigger ContainerAppScaleRuleTrigger) bicepObject {
+	out := bicepObject{}
+	if trigger.Type != "" {
+		out = append(out, bicepProperty{"type", trigger.Type})
+	}
+	if len(trigger.Metadata) > 0 {
+		metadata := bicepObject{}
+		for _, k := range slices.Sorted(maps.Keys(trigger.Metadata)) {
+			metadata = append(metadata, bicepProperty{k, trigger.Metadata[k]})
+		}
+		out = append(out, bicepProperty{"metadata", metadata})
+	}
+	if len(trigger.Auth) > 0 {
+		auth := bicepArray{}
+		for _, a := range trigger.Auth {
+			auth = append(auth, bicepObject{{"secretRef", a.SecretRef}, {"triggerParameter", a.TriggerParameter}})
+		}
+		out = append(out, bicepProperty{"auth", auth})
+	}
+	return out
+}
@@ -3,6 +3,10 @@ package convert
 const (
 	bicepHeader = `// Generated by score-aca
 // Azure Container Apps Bicep manifest
+`
+
+	bicepModuleHeader = `// Generated by score-aca
+// Azure Container Apps Bicep module for %s
 `
 
 	// TODO: Pull Environment version from Azure
@@ -18,8 +22,9 @@ resource containerAppEnvironment 'Microsoft.App/managedEnvironments@2024-03-01'
 }
 `
 
-	bicepOutputs = `
-// Outputs
-output containerAppFQDN string = containerApp.properties.configuration.ingress.fqdn
+	bicepExistingContainerAppEnvironment = `// Container App Environment
+resource containerAppEnvironment 'Microsoft.App/managedEnvironments@2024-03-01' existing = {
+  name: environmentName
+}
 `
 )
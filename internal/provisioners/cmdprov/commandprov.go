@@ -0,0 +1,137 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdprov
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/score-spec/score-aca/internal/provisioners"
+)
+
+// Scheme is the uri scheme of command provisioners.
+const Scheme = "cmd"
+
+// DefaultTimeout is the time a command is given to provision a resource when no timeout is configured.
+const DefaultTimeout = 30 * time.Second
+
+// Provisioner is a provisioner that executes a local binary. The binary receives the provisioners.Input as a json
+// document on stdin and must write a provisioners.ProvisionOutput json document to stdout.
+type Provisioner struct {
+	provisioners.ResourceMatcher `yaml:",inline"`
+
+	// Description is an optional human-readable description of the provisioner.
+	Description string `yaml:"description,omitempty"`
+	// Args are the additional arguments passed to the binary.
+	Args []string `yaml:"args,omitempty"`
+	// Timeout is the maximum duration of the command, for example "2m". Defaults to DefaultTimeout.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// decodeBinary returns the binary referenced by the uri. The uri can reference a binary on the PATH (cmd://python3),
+// a path relative to the current directory (cmd://./bin/provision), a path relative to the home directory
+// (cmd://~/bin/provision), or an absolute path (cmd:///usr/local/bin/provision).
+func decodeBinary(uri string) (string, error) {
+	parts, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse uri: %w", err)
+	}
+	switch parts.Host {
+	case "":
+		if parts.Path == "" {
+			return "", fmt.Errorf("uri '%s' has no binary", uri)
+		}
+		return parts.Path, nil
+	case ".", "..":
+		// keep the path anchored to the current directory, a bare name would be looked up on the PATH instead
+		bin := filepath.Join(parts.Host, parts.Path)
+		if bin != ".." && !strings.HasPrefix(bin, ".."+string(filepath.Separator)) {
+			bin = "." + string(filepath.Separator) + bin
+		}
+		return bin, nil
+	case "~":
+		hd, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to determine home directory: %w", err)
+		}
+		return filepath.Join(hd, parts.Path), nil
+	default:
+		if parts.Path != "" {
+			return "", fmt.Errorf("uri '%s' references a binary on the PATH and cannot contain a path", uri)
+		}
+		bin, err := exec.LookPath(parts.Host)
+		if err != nil {
+			return "", fmt.Errorf("failed to find '%s' on the PATH: %w", parts.Host, err)
+		}
+		return bin, nil
+	}
+}
+
+// Provision runs the binary with a timeout. Anything the binary writes to stderr is included in the returned error.
+func (p *Provisioner) Provision(ctx context.Context, input *provisioners.Input) (*provisioners.ProvisionOutput, error) {
+	bin, err := decodeBinary(p.Uri())
+	if err != nil {
+		return nil, err
+	}
+	rawInput, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode input: %w", err)
+	}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cmd := exec.CommandContext(ctx, bin, p.Args...)
+	cmd.Stdin = bytes.NewReader(rawInput)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	slog.Debug(fmt.Sprintf("Executing '%s %v' for resource '%s'", bin, p.Args, input.ResourceUid))
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to execute '%s': %w: %s", bin, err, msg)
+		}
+		return nil, fmt.Errorf("failed to execute '%s': %w", bin, err)
+	} else if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		slog.Debug(fmt.Sprintf("Command '%s' wrote to stderr: %s", bin, msg))
+	}
+
+	var output provisioners.ProvisionOutput
+	dec := json.NewDecoder(stdout)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&output); err != nil {
+		return nil, fmt.Errorf("failed to decode output from '%s': %w", bin, err)
+	}
+	return &output, nil
+}
+
+var _ provisioners.Provisioner = (*Provisioner)(nil)
@@ -0,0 +1,143 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdprov
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/score-spec/score-aca/internal/provisioners"
+)
+
+const fakeModeEnv = "SCORE_ACA_FAKE_PROVISIONER_MODE"
+
+// TestMain lets the test binary act as a fake provisioner binary when the fake mode env var is set.
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(fakeModeEnv); mode != "" {
+		os.Exit(runFakeProvisioner(mode))
+	}
+	os.Exit(m.Run())
+}
+
+func runFakeProvisioner(mode string) int {
+	switch mode {
+	case "echo":
+		var input provisioners.Input
+		if err := json.NewDecoder(os.Stdin).Decode(&input); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "bad input: %v", err)
+			return 1
+		}
+		_ = json.NewEncoder(os.Stdout).Encode(provisioners.ProvisionOutput{
+			ResourceState:   map[string]interface{}{"previous": input.ResourceState},
+			ResourceOutputs: map[string]interface{}{"uid": input.ResourceUid, "size": input.ResourceParams["size"], "args": os.Args[1:]},
+			SharedState:     map[string]interface{}{"seen": input.SharedState["seen"].(float64) + 1},
+			Bicep:           fmt.Sprintf("// bicep for %s", input.ResourceUid),
+		})
+		return 0
+	case "fail":
+		_, _ = fmt.Fprint(os.Stderr, "something went wrong\n")
+		return 3
+	case "sleep":
+		time.Sleep(time.Minute)
+		return 0
+	case "garbage":
+		_, _ = fmt.Fprint(os.Stdout, `{"unknown": true}`)
+		return 0
+	}
+	return 1
+}
+
+func newFakeProvisioner(t *testing.T, mode string) *Provisioner {
+	t.Setenv(fakeModeEnv, mode)
+	bin, err := filepath.Abs(os.Args[0])
+	require.NoError(t, err)
+	p := &Provisioner{Args: []string{"a", "b"}}
+	p.ProvisionerUri = "cmd://" + bin
+	p.ResType = "thing"
+	return p
+}
+
+func TestProvision_nominal(t *testing.T) {
+	p := newFakeProvisioner(t, "echo")
+	out, err := p.Provision(context.Background(), &provisioners.Input{
+		ResourceUid:    "thing.default#example.thing",
+		ResourceParams: map[string]interface{}{"size": "large"},
+		ResourceState:  map[string]interface{}{"a": "b"},
+		SharedState:    map[string]interface{}{"seen": 1},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &provisioners.ProvisionOutput{
+		ResourceState:   map[string]interface{}{"previous": map[string]interface{}{"a": "b"}},
+		ResourceOutputs: map[string]interface{}{"uid": "thing.default#example.thing", "size": "large", "args": []interface{}{"a", "b"}},
+		SharedState:     map[string]interface{}{"seen": float64(2)},
+		Bicep:           "// bicep for thing.default#example.thing",
+	}, out)
+}
+
+func TestProvision_failure_includes_stderr(t *testing.T) {
+	p := newFakeProvisioner(t, "fail")
+	_, err := p.Provision(context.Background(), &provisioners.Input{})
+	assert.EqualError(t, err, fmt.Sprintf("failed to execute '%s': exit status 3: something went wrong", p.Uri()[len("cmd://"):]))
+}
+
+func TestProvision_timeout(t *testing.T) {
+	p := newFakeProvisioner(t, "sleep")
+	p.Timeout = 100 * time.Millisecond
+	_, err := p.Provision(context.Background(), &provisioners.Input{})
+	assert.EqualError(t, err, fmt.Sprintf("failed to execute '%s': timed out after 100ms", p.Uri()[len("cmd://"):]))
+}
+
+func TestProvision_bad_output(t *testing.T) {
+	p := newFakeProvisioner(t, "garbage")
+	_, err := p.Provision(context.Background(), &provisioners.Input{})
+	assert.EqualError(t, err, fmt.Sprintf("failed to decode output from '%s': json: unknown field \"unknown\"", p.Uri()[len("cmd://"):]))
+}
+
+func TestDecodeBinary(t *testing.T) {
+	hd, _ := os.UserHomeDir()
+	for _, tc := range []struct {
+		uri      string
+		expected string
+		err      string
+	}{
+		{uri: "cmd:///usr/bin/thing", expected: "/usr/bin/thing"},
+		{uri: "cmd://./bin/thing", expected: "./bin/thing"},
+		{uri: "cmd://./prov", expected: "./prov"},
+		{uri: "cmd://./..prov", expected: "./..prov"},
+		{uri: "cmd://../bin/thing", expected: "../bin/thing"},
+		{uri: "cmd://~/bin/thing", expected: filepath.Join(hd, "bin/thing")},
+		{uri: "cmd://", err: "uri 'cmd://' has no binary"},
+		{uri: "cmd://thing/sub", err: "uri 'cmd://thing/sub' references a binary on the PATH and cannot contain a path"},
+		{uri: "cmd://score-aca-missing-binary", err: "failed to find 'score-aca-missing-binary' on the PATH: exec: \"score-aca-missing-binary\": executable file not found in $PATH"},
+	} {
+		t.Run(tc.uri, func(t *testing.T) {
+			bin, err := decodeBinary(tc.uri)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, bin)
+			}
+		})
+	}
+}
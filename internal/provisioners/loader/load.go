@@ -0,0 +1,129 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/score-spec/score-aca/internal/provisioners"
+	"github.com/score-spec/score-aca/internal/provisioners/cmdprov"
+	"github.com/score-spec/score-aca/internal/provisioners/templateprov"
+)
+
+// DefaultSuffix is the file suffix of provisioner files loaded from the state directory.
+const DefaultSuffix = ".provisioners.yaml"
+
+// LoadProvisioners parses a yaml list of provisioner definitions. The order of the list is preserved so that the
+// first provisioner matching a resource wins.
+func LoadProvisioners(raw []byte) ([]provisioners.Provisioner, error) {
+	var intermediate []yaml.Node
+	if err := yaml.Unmarshal(raw, &intermediate); err != nil {
+		return nil, fmt.Errorf("failed to decode file: %w", err)
+	}
+	out := make([]provisioners.Provisioner, 0, len(intermediate))
+	uris := make(map[string]bool, len(intermediate))
+	for i, node := range intermediate {
+		var header provisioners.ResourceMatcher
+		if err := node.Decode(&header); err != nil {
+			return nil, fmt.Errorf("%d: failed to decode: %w", i, err)
+		} else if header.ProvisionerUri == "" {
+			return nil, fmt.Errorf("%d: uri: missing", i)
+		} else if header.ResType == "" {
+			return nil, fmt.Errorf("%d: type: missing", i)
+		} else if uris[header.ProvisionerUri] {
+			return nil, fmt.Errorf("%d: uri: '%s' is defined more than once", i, header.ProvisionerUri)
+		}
+		uris[header.ProvisionerUri] = true
+
+		u, err := url.Parse(header.ProvisionerUri)
+		if err != nil {
+			return nil, fmt.Errorf("%d: uri: failed to parse: %w", i, err)
+		}
+		switch u.Scheme {
+		case templateprov.Scheme:
+			p := new(templateprov.Provisioner)
+			if err := decodeStrict(&intermediate[i], p); err != nil {
+				return nil, fmt.Errorf("%d: %s: failed to decode: %w", i, header.ProvisionerUri, err)
+			}
+			out = append(out, p)
+		case cmdprov.Scheme:
+			p := new(cmdprov.Provisioner)
+			if err := decodeStrict(&intermediate[i], p); err != nil {
+				return nil, fmt.Errorf("%d: %s: failed to decode: %w", i, header.ProvisionerUri, err)
+			}
+			out = append(out, p)
+		default:
+			return nil, fmt.Errorf("%d: uri: unsupported provisioner scheme '%s'", i, u.Scheme)
+		}
+	}
+	return out, nil
+}
+
+// decodeStrict decodes a provisioner definition into the given kind-specific structure, rejecting unknown fields.
+func decodeStrict(node *yaml.Node, out interface{}) error {
+	raw, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	return dec.Decode(out)
+}
+
+// LoadProvisionersFromDirectory loads all files in the directory with the given suffix. Files are read in
+// lexicographic order so that a file like "00-custom.provisioners.yaml" takes precedence over
+// "zz-default.provisioners.yaml". Each uri must be unique across all files.
+func LoadProvisionersFromDirectory(path string, suffix string) ([]provisioners.Provisioner, error) {
+	items, err := os.ReadDir(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list directory '%s': %w", path, err)
+	}
+	out := make([]provisioners.Provisioner, 0)
+	uriFiles := make(map[string]string)
+	for _, item := range items {
+		if item.IsDir() || !strings.HasSuffix(item.Name(), suffix) {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(path, item.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s': %w", item.Name(), err)
+		}
+		loaded, err := LoadProvisioners(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load '%s': %w", item.Name(), err)
+		}
+		for i, p := range loaded {
+			if other, ok := uriFiles[p.Uri()]; ok {
+				return nil, fmt.Errorf("failed to load '%s': %d: uri: '%s' is already defined in '%s'", item.Name(), i, p.Uri(), other)
+			}
+			uriFiles[p.Uri()] = item.Name()
+		}
+		slog.Info(fmt.Sprintf("Loaded %d provisioners from '%s'", len(loaded), item.Name()))
+		out = append(out, loaded...)
+	}
+	return out, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/score-spec/score-aca/internal/provisioners/cmdprov"
+)
+
+func TestLoadProvisioners_empty(t *testing.T) {
+	p, err := LoadProvisioners([]byte(`[]`))
+	assert.NoError(t, err)
+	assert.Len(t, p, 0)
+}
+
+func TestLoadProvisioners_invalid(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		raw    string
+		expect string
+	}{
+		{"not a list", `{}`, "failed to decode file: yaml: unmarshal errors:\n  line 1: cannot unmarshal !!map into []yaml.Node"},
+		{"missing uri", `[{type: postgres}]`, "0: uri: missing"},
+		{"missing type", `[{uri: "template://example"}]`, "0: type: missing"},
+		{"unknown scheme", `[{uri: "blah://example", type: postgres}]`, "0: uri: unsupported provisioner scheme 'blah'"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadProvisioners([]byte(tc.raw))
+			assert.EqualError(t, err, tc.expect)
+		})
+	}
+}
+
+func TestLoadProvisionersFromDirectory_missing(t *testing.T) {
+	p, err := LoadProvisionersFromDirectory(filepath.Join(t.TempDir(), "missing"), DefaultSuffix)
+	assert.NoError(t, err)
+	assert.Len(t, p, 0)
+}
+
+func TestLoadProvisionersFromDirectory_ignores_other_files(t *testing.T) {
+	td := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "state.yaml"), []byte(`{}`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "a"+DefaultSuffix), []byte(`[]`), 0644))
+	p, err := LoadProvisionersFromDirectory(td, DefaultSuffix)
+	assert.NoError(t, err)
+	assert.Len(t, p, 0)
+}
+
+func TestLoadProvisionersFromDirectory_bad_file(t *testing.T) {
+	td := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "a"+DefaultSuffix), []byte(`[{type: postgres}]`), 0644))
+	_, err := LoadProvisionersFromDirectory(td, DefaultSuffix)
+	assert.EqualError(t, err, "failed to load 'a.provisioners.yaml': 0: uri: missing")
+}
+
+func TestLoadProvisionersFromDirectory_duplicate_uri(t *testing.T) {
+	td := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "00-custom"+DefaultSuffix), []byte(`[{uri: "template://default-provisioners/redis", type: redis}]`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(td, "zz-default"+DefaultSuffix), []byte(`[{uri: "template://default-provisioners/postgres", type: postgres}, {uri: "template://default-provisioners/redis", type: redis}]`), 0644))
+	_, err := LoadProvisionersFromDirectory(td, DefaultSuffix)
+	assert.EqualError(t, err, "failed to load 'zz-default.provisioners.yaml': 1: uri: 'template://default-provisioners/redis' is already defined in '00-custom.provisioners.yaml'")
+}
+
+func TestLoadProvisioners_template(t *testing.T) {
+	p, err := LoadProvisioners([]byte(`
+- uri: template://example/a
+  type: postgres
+  class: large
+  outputs: |
+    host: example
+- uri: template://example/b
+  type: redis
+`))
+	assert.NoError(t, err)
+	if assert.Len(t, p, 2) {
+		assert.Equal(t, "template://example/a", p[0].Uri())
+		assert.Equal(t, "template://example/b", p[1].Uri())
+	}
+}
+
+func TestLoadProvisioners_template_unknown_field(t *testing.T) {
+	_, err := LoadProvisioners([]byte(`
+- uri: template://example/a
+  type: postgres
+  manifests: |
+    blah
+`))
+	assert.EqualError(t, err, "0: template://example/a: failed to decode: yaml: unmarshal errors:\n  line 3: field manifests not found in type templateprov.Provisioner")
+}
+
+func TestLoadProvisioners_duplicate_uri(t *testing.T) {
+	_, err := LoadProvisioners([]byte(`
+- uri: template://example/a
+  type: postgres
+- uri: template://example/a
+  type: redis
+`))
+	assert.EqualError(t, err, "1: uri: 'template://example/a' is defined more than once")
+}
+
+func TestLoadProvisioners_cmd(t *testing.T) {
+	p, err := LoadProvisioners([]byte(`
+- uri: cmd://python3
+  type: postgres
+  args: ["./provision.py"]
+  timeout: 2m
+`))
+	assert.NoError(t, err)
+	if assert.Len(t, p, 1) {
+		assert.Equal(t, "cmd://python3", p[0].Uri())
+		assert.Equal(t, 2*time.Minute, p[0].(*cmdprov.Provisioner).Timeout)
+	}
+}
@@ -15,15 +15,95 @@
 package provisioners
 
 import (
+	"context"
 	"fmt"
 	"maps"
+	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/score-spec/score-go/framework"
 
+	"github.com/score-spec/score-aca/internal/convert"
 	"github.com/score-spec/score-aca/internal/state"
 )
 
-func ProvisionResources(currentState *state.State) (*state.State, error) {
+// Input is the set of things passed to the provisioner implementation. It provides context, previous state, and shared
+// state used by all resources.
+type Input struct {
+	// -- aspects from the resource declaration --
+
+	ResourceUid      string                 `json:"resource_uid"`
+	ResourceType     string                 `json:"resource_type"`
+	ResourceClass    string                 `json:"resource_class"`
+	ResourceId       string                 `json:"resource_id"`
+	ResourceParams   map[string]interface{} `json:"resource_params"`
+	ResourceMetadata map[string]interface{} `json:"resource_metadata"`
+
+	// -- aspects from the workload that first declared the resource --
+
+	SourceWorkload   string                 `json:"source_workload"`
+	WorkloadMetadata map[string]interface{} `json:"workload_metadata"`
+
+	// -- current state --
+
+	ResourceState map[string]interface{} `json:"resource_state"`
+	SharedState   map[string]interface{} `json:"shared_state"`
+}
+
+// ProvisionOutput is the output returned from a provisioner implementation.
+type ProvisionOutput struct {
+	ResourceState   map[string]interface{} `json:"resource_state"`
+	ResourceOutputs map[string]interface{} `json:"resource_outputs"`
+	SharedState     map[string]interface{} `json:"shared_state"`
+	// Bicep holds optional Bicep declarations that are added to the generated manifest next to the container app.
+	Bicep string `json:"bicep,omitempty"`
+	// SecretOutputs lists the outputs that hold sensitive values, nested outputs are joined with a '.'. Container
+	// variables that use them are passed through Container App secrets.
+	SecretOutputs []string `json:"secret_outputs,omitempty"`
+	// RoleAssignments lists roles on the resources declared in the Bicep that are granted to the system-assigned
+	// identity of each container app using the resource.
+	RoleAssignments []state.RoleAssignment `json:"role_assignments,omitempty"`
+}
+
+// roleDefinitionIdRegex matches the guid of a role definition
+var roleDefinitionIdRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Provisioner is the interface implemented by each kind of provisioner that can be loaded from a provisioners file.
+type Provisioner interface {
+	Uri() string
+	Match(resUid framework.ResourceUid) bool
+	Provision(ctx context.Context, input *Input) (*ProvisionOutput, error)
+}
+
+// ResourceMatcher holds the fields common to all provisioner definitions. It identifies the provisioner and selects
+// the resources it applies to. A nil class or id matches any class or id.
+type ResourceMatcher struct {
+	ProvisionerUri string  `yaml:"uri"`
+	ResType        string  `yaml:"type"`
+	ResClass       *string `yaml:"class,omitempty"`
+	ResId          *string `yaml:"id,omitempty"`
+}
+
+// Uri returns the unique uri of the provisioner.
+func (m *ResourceMatcher) Uri() string {
+	return m.ProvisionerUri
+}
+
+// Match returns true if the resource type, class, and id are accepted by this provisioner.
+func (m *ResourceMatcher) Match(resUid framework.ResourceUid) bool {
+	if resUid.Type() != m.ResType {
+		return false
+	} else if m.ResClass != nil && resUid.Class() != *m.ResClass {
+		return false
+	} else if m.ResId != nil && resUid.Id() != *m.ResId {
+		return false
+	}
+	return true
+}
+
+// ProvisionResources provisions each resource in the state in dependency order using the first matching provisioner.
+func ProvisionResources(ctx context.Context, currentState *state.State, provisioners []Provisioner) (*state.State, error) {
 	out := currentState
 
 	// provision in sorted order
@@ -36,6 +116,17 @@ func ProvisionResources(currentState *state.State) (*state.State, error) {
 	for _, resUid := range orderedResources {
 		resState := out.Resources[resUid]
 
+		provisionerIndex := slices.IndexFunc(provisioners, func(p Provisioner) bool {
+			return p.Match(resUid)
+		})
+		if provisionerIndex < 0 {
+			return nil, fmt.Errorf("%s: resource is not supported by any provisioner", resUid)
+		}
+		provisioner := provisioners[provisionerIndex]
+		if resState.ProvisionerUri != "" && resState.ProvisionerUri != provisioner.Uri() {
+			return nil, fmt.Errorf("%s: resource was previously provisioned by a different provisioner - previous=%s current=%s", resUid, resState.ProvisionerUri, provisioner.Uri())
+		}
+
 		var params map[string]interface{}
 		if len(resState.Params) > 0 {
 			resOutputs, err := out.GetResourceOutputForWorkload(resState.SourceWorkload)
@@ -51,13 +142,84 @@ func ProvisionResources(currentState *state.State) (*state.State, error) {
 		}
 		resState.Params = params
 
-		// ==========================================================================================
-		// TODO: HERE IS WHERE YOU WOULD USE THE RESOURCE TYPE, CLASS, ID, AND PARAMS TO PROVISION IT
-		// ==========================================================================================
+		output, err := provisioner.Provision(ctx, &Input{
+			ResourceUid:      string(resUid),
+			ResourceType:     resUid.Type(),
+			ResourceClass:    resUid.Class(),
+			ResourceId:       resUid.Id(),
+			ResourceParams:   params,
+			ResourceMetadata: resState.Metadata,
+			SourceWorkload:   resState.SourceWorkload,
+			WorkloadMetadata: out.Workloads[resState.SourceWorkload].Spec.Metadata,
+			ResourceState:    resState.State,
+			SharedState:      out.SharedState,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to provision with '%s': %w", resUid, provisioner.Uri(), err)
+		}
 
-		resState.Outputs = map[string]interface{}{}
+		resState.ProvisionerUri = provisioner.Uri()
+		resState.State = output.ResourceState
+		if resState.State == nil {
+			resState.State = map[string]interface{}{}
+		}
+		resState.Outputs = output.ResourceOutputs
+		if resState.Outputs == nil {
+			resState.Outputs = map[string]interface{}{}
+		}
+		resState.OutputLookupFunc = buildOutputLookupFunc(resState.Outputs)
+		resState.Extras.Bicep = output.Bicep
+		for _, key := range output.SecretOutputs {
+			if _, err := resState.OutputLookupFunc(strings.Split(key, ".")...); err != nil {
+				return nil, fmt.Errorf("%s: secret output '%s' is not an output of the resource: %w", resUid, key, err)
+			}
+		}
+		resState.Extras.SecretOutputs = slices.Sorted(slices.Values(output.SecretOutputs))
+		for i, ra := range output.RoleAssignments {
+			if !roleDefinitionIdRegex.MatchString(ra.Role) {
+				return nil, fmt.Errorf("%s: role assignment %d: role '%s' is not the guid of a role definition", resUid, i, ra.Role)
+			} else if !convert.DeclaresResource(output.Bicep, ra.Scope) {
+				return nil, fmt.Errorf("%s: role assignment %d: scope '%s' is not a resource declared in the Bicep of the resource", resUid, i, ra.Scope)
+			}
+		}
+		resState.Extras.RoleAssignments = output.RoleAssignments
+		out.SharedState = PatchSharedState(out.SharedState, output.SharedState)
 		out.Resources[resUid] = resState
 	}
 
 	return out, nil
 }
+
+// buildOutputLookupFunc returns a lookup function that walks the nested outputs map of a resource.
+func buildOutputLookupFunc(outputs map[string]interface{}) framework.OutputLookupFunc {
+	return func(keys ...string) (interface{}, error) {
+		var resolvedValue interface{} = outputs
+		for _, k := range keys {
+			mapV, ok := resolvedValue.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot lookup key '%s', context is not a map", k)
+			}
+			if resolvedValue, ok = mapV[k]; !ok {
+				return nil, fmt.Errorf("key '%s' not found", k)
+			}
+		}
+		return resolvedValue, nil
+	}
+}
+
+// PatchSharedState merges the shared state returned by a provisioner into the current shared state. Keys set to nil
+// are removed.
+func PatchSharedState(current map[string]interface{}, patch map[string]interface{}) map[string]interface{} {
+	out := maps.Clone(current)
+	if out == nil {
+		out = map[string]interface{}{}
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(out, k)
+		} else {
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioners
+
+import (
+	"context"
+	"testing"
+
+	"github.com/score-spec/score-go/framework"
+	scoretypes "github.com/score-spec/score-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/score-spec/score-aca/internal/state"
+)
+
+type fakeProvisioner struct {
+	ResourceMatcher
+	output *ProvisionOutput
+	inputs []*Input
+}
+
+func (f *fakeProvisioner) Provision(ctx context.Context, input *Input) (*ProvisionOutput, error) {
+	f.inputs = append(f.inputs, input)
+	return f.output, nil
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func newTestState(t *testing.T, resources map[string]scoretypes.Resource) *state.State {
+	t.Helper()
+	s := &state.State{SharedState: map[string]interface{}{"existing": "value"}}
+	s, err := s.WithWorkload(&scoretypes.Workload{
+		Metadata:   map[string]interface{}{"name": "example"},
+		Containers: map[string]scoretypes.Container{"main": {Image: "nginx"}},
+		Resources:  resources,
+	}, nil, state.WorkloadExtras{})
+	require.NoError(t, err)
+	s, err = s.WithPrimedResources()
+	require.NoError(t, err)
+	return s
+}
+
+func TestResourceMatcher(t *testing.T) {
+	uid := framework.NewResourceUid("example", "db", "postgres", stringPtr("large"), nil)
+	assert.True(t, (&ResourceMatcher{ResType: "postgres"}).Match(uid))
+	assert.True(t, (&ResourceMatcher{ResType: "postgres", ResClass: stringPtr("large")}).Match(uid))
+	assert.True(t, (&ResourceMatcher{ResType: "postgres", ResId: stringPtr("example.db")}).Match(uid))
+	assert.False(t, (&ResourceMatcher{ResType: "redis"}).Match(uid))
+	assert.False(t, (&ResourceMatcher{ResType: "postgres", ResClass: stringPtr("default")}).Match(uid))
+	assert.False(t, (&ResourceMatcher{ResType: "postgres", ResId: stringPtr("other")}).Match(uid))
+}
+
+func TestProvisionResources_first_match_wins(t *testing.T) {
+	s := newTestState(t, map[string]scoretypes.Resource{"db": {Type: "postgres"}})
+	first := &fakeProvisioner{
+		ResourceMatcher: ResourceMatcher{ProvisionerUri: "test://first", ResType: "postgres"},
+		output: &ProvisionOutput{
+			ResourceOutputs: map[string]interface{}{"host": "first", "nested": map[string]interface{}{"port": 5432}},
+			SharedState:     map[string]interface{}{"existing": nil, "added": "value"},
+		},
+	}
+	second := &fakeProvisioner{
+		ResourceMatcher: ResourceMatcher{ProvisionerUri: "test://second", ResType: "postgres"},
+		output:          &ProvisionOutput{},
+	}
+
+	out, err := ProvisionResources(context.Background(), s, []Provisioner{first, second})
+	require.NoError(t, err)
+	assert.Len(t, first.inputs, 1)
+	assert.Len(t, second.inputs, 0)
+	assert.Equal(t, "postgres.default#example.db", first.inputs[0].ResourceUid)
+	assert.Equal(t, "example", first.inputs[0].SourceWorkload)
+	assert.Equal(t, map[string]interface{}{"added": "value"}, out.SharedState)
+
+	resState := out.Resources["postgres.default#example.db"]
+	assert.Equal(t, "test://first", resState.ProvisionerUri)
+	assert.Equal(t, map[string]interface{}{}, resState.State)
+
+	outputs, err := out.GetResourceOutputForWorkload("example")
+	require.NoError(t, err)
+	sf := framework.BuildSubstitutionFunction(out.Workloads["example"].Spec.Metadata, outputs)
+	v, err := framework.SubstituteString("${resources.db.host}:${resources.db.nested.port}", sf)
+	assert.NoError(t, err)
+	assert.Equal(t, "first:5432", v)
+	_, err = framework.SubstituteString("${resources.db.unknown}", sf)
+	assert.Error(t, err)
+}
+
+func TestProvisionResources_no_match(t *testing.T) {
+	s := newTestState(t, map[string]scoretypes.Resource{"db": {Type: "postgres"}})
+	_, err := ProvisionResources(context.Background(), s, []Provisioner{
+		&fakeProvisioner{ResourceMatcher: ResourceMatcher{ProvisionerUri: "test://redis", ResType: "redis"}},
+	})
+	assert.EqualError(t, err, "postgres.default#example.db: resource is not supported by any provisioner")
+}
+
+func TestProvisionResources_provisioner_changed(t *testing.T) {
+	s := newTestState(t, map[string]scoretypes.Resource{"db": {Type: "postgres"}})
+	resState := s.Resources["postgres.default#example.db"]
+	resState.ProvisionerUri = "test://old"
+	s.Resources["postgres.default#example.db"] = resState
+	_, err := ProvisionResources(context.Background(), s, []Provisioner{
+		&fakeProvisioner{ResourceMatcher: ResourceMatcher{ProvisionerUri: "test://new", ResType: "postgres"}, output: &ProvisionOutput{}},
+	})
+	assert.EqualError(t, err, "postgres.default#example.db: resource was previously provisioned by a different provisioner - previous=test://old current=test://new")
+}
+
+func TestProvisionResources_secret_outputs(t *testing.T) {
+	s := newTestState(t, map[string]scoretypes.Resource{"db": {Type: "postgres"}})
+	out, err := ProvisionResources(context.Background(), s, []Provisioner{
+		&fakeProvisioner{ResourceMatcher: ResourceMatcher{ProvisionerUri: "test://postgres", ResType: "postgres"}, output: &ProvisionOutput{
+			ResourceOutputs: map[string]interface{}{"password": "secret", "nested": map[string]interface{}{"key": "secret"}},
+			SecretOutputs:   []string{"password", "nested.key"},
+		}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nested.key", "password"}, out.Resources["postgres.default#example.db"].Extras.SecretOutputs)
+
+	s = newTestState(t, map[string]scoretypes.Resource{"db": {Type: "postgres"}})
+	_, err = ProvisionResources(context.Background(), s, []Provisioner{
+		&fakeProvisioner{ResourceMatcher: ResourceMatcher{ProvisionerUri: "test://postgres", ResType: "postgres"}, output: &ProvisionOutput{
+			SecretOutputs: []string{"password"},
+		}},
+	})
+	assert.EqualError(t, err, "postgres.default#example.db: secret output 'password' is not an output of the resource: key 'password' not found")
+}
+
+func TestProvisionResources_role_assignments(t *testing.T) {
+	bicep := "resource storage_data 'Microsoft.Storage/storageAccounts@2023-01-01' = {\n  name: 'data'\n}"
+	reader := state.RoleAssignment{Scope: "storage_data", Role: "2a2b9908-6ea1-4ae2-8e65-a410df84e7d1"}
+	s := newTestState(t, map[string]scoretypes.Resource{"data": {Type: "storage"}})
+	out, err := ProvisionResources(context.Background(), s, []Provisioner{
+		&fakeProvisioner{ResourceMatcher: ResourceMatcher{ProvisionerUri: "test://storage", ResType: "storage"}, output: &ProvisionOutput{
+			Bicep:           bicep,
+			RoleAssignments: []state.RoleAssignment{reader},
+		}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []state.RoleAssignment{reader}, out.Resources["storage.default#example.data"].Extras.RoleAssignments)
+
+	for _, tc := range []struct {
+		ra       state.RoleAssignment
+		expected string
+	}{
+		{
+			ra:       state.RoleAssignment{Scope: "storage_data", Role: "Storage Blob Data Reader"},
+			expected: "storage.default#example.data: role assignment 0: role 'Storage Blob Data Reader' is not the guid of a role definition",
+		},
+		{
+			ra:       state.RoleAssignment{Scope: "storage", Role: reader.Role},
+			expected: "storage.default#example.data: role assignment 0: scope 'storage' is not a resource declared in the Bicep of the resource",
+		},
+	} {
+		s = newTestState(t, map[string]scoretypes.Resource{"data": {Type: "storage"}})
+		_, err = ProvisionResources(context.Background(), s, []Provisioner{
+			&fakeProvisioner{ResourceMatcher: ResourceMatcher{ProvisionerUri: "test://storage", ResType: "storage"}, output: &ProvisionOutput{
+				Bicep:           bicep,
+				RoleAssignments: []state.RoleAssignment{tc.ra},
+			}},
+		})
+		assert.EqualError(t, err, tc.expected)
+	}
+}
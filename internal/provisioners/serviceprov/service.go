@@ -0,0 +1,108 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serviceprov
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/score-spec/score-go/framework"
+
+	"github.com/score-spec/score-aca/internal/convert"
+	"github.com/score-spec/score-aca/internal/provisioners"
+	"github.com/score-spec/score-aca/internal/state"
+)
+
+const (
+	// Uri is the uri of the built-in service provisioner.
+	Uri = convert.ServiceProvisionerUri
+	// ResourceType is the resource type handled by the built-in service provisioner.
+	ResourceType = "service"
+)
+
+// Provisioner is a built-in provisioner that resolves a 'service' resource to the address of another workload of the
+// project. Since the container apps share one environment, the workload can be reached through the name of its
+// container app.
+type Provisioner struct {
+	provisioners.ResourceMatcher
+
+	workloads map[string]framework.ScoreWorkloadState[state.WorkloadExtras]
+}
+
+// New returns a service provisioner that resolves services against the workloads of the given state.
+func New(currentState *state.State) *Provisioner {
+	return &Provisioner{
+		ResourceMatcher: provisioners.ResourceMatcher{ProvisionerUri: Uri, ResType: ResourceType},
+		workloads:       currentState.Workloads,
+	}
+}
+
+// Provision returns the outputs of the workload named by the 'workload' param. The default name of its container app is
+// used as host, which the conversion replaces by the name parameter of the container app:
+//   - host: the name of the container app, which resolves within the environment
+//   - port: the port the ingress listens on within the environment
+//   - target_port: the container port the ingress forwards to
+//   - ports: the port that each service port is reachable on within the environment, by port name
+//   - fqdn: the internal fully qualified domain name of the container app
+//   - url: the url of the container app within the environment
+func (p *Provisioner) Provision(ctx context.Context, input *provisioners.Input) (*provisioners.ProvisionOutput, error) {
+	rawWorkloadName, ok := input.ResourceParams["workload"]
+	if !ok {
+		return nil, fmt.Errorf("missing 'workload' param")
+	}
+	workloadName, ok := rawWorkloadName.(string)
+	if !ok || workloadName == "" {
+		return nil, fmt.Errorf("'workload' param must be a non-empty string")
+	}
+	workload, ok := p.workloads[workloadName]
+	if !ok {
+		return nil, fmt.Errorf("workload '%s' does not exist in the project", workloadName)
+	}
+	ingress, err := convert.ConvertIngress(workload.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("workload '%s': %w", workloadName, err)
+	} else if ingress == nil {
+		return nil, fmt.Errorf("workload '%s' has no service ports", workloadName)
+	}
+	primaryPort, err := convert.IngressPortName(workload.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("workload '%s': %w", workloadName, err)
+	}
+
+	// http ingress is reachable on port 80 within the environment, tcp ingress on its exposed port
+	host := convert.DefaultContainerAppName(workloadName)
+	port, url := 80, "http://"+host
+	if ingress.Transport == "tcp" {
+		port = ingress.ExposedPort
+		url = fmt.Sprintf("tcp://%s:%d", host, port)
+	}
+	ports := make(map[string]interface{}, len(workload.Spec.Service.Ports))
+	for name, p := range workload.Spec.Service.Ports {
+		ports[name] = p.Port
+	}
+	ports[primaryPort] = port
+
+	return &provisioners.ProvisionOutput{
+		ResourceState: map[string]interface{}{convert.ServiceWorkloadStateKey: workloadName},
+		ResourceOutputs: map[string]interface{}{
+			"host":        host,
+			"port":        port,
+			"target_port": ingress.TargetPort,
+			"ports":       ports,
+			"fqdn":        host + ".internal.${containerAppEnvironment.properties.defaultDomain}",
+			"url":         url,
+		},
+	}, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serviceprov
+
+import (
+	"context"
+	"testing"
+
+	"github.com/score-spec/score-go/framework"
+	scoretypes "github.com/score-spec/score-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/score-spec/score-aca/internal/provisioners"
+	"github.com/score-spec/score-aca/internal/state"
+)
+
+func TestProvision(t *testing.T) {
+	targetPort := 9898
+	tcp := scoretypes.ServicePortProtocolTCP
+	p := New(&state.State{
+		Workloads: map[string]framework.ScoreWorkloadState[state.WorkloadExtras]{
+			"backend": {Spec: scoretypes.Workload{Service: &scoretypes.WorkloadService{Ports: scoretypes.WorkloadServicePorts{
+				"web": {Port: 80, TargetPort: &targetPort},
+			}}}},
+			"api": {Spec: scoretypes.Workload{Service: &scoretypes.WorkloadService{Ports: scoretypes.WorkloadServicePorts{
+				"admin": {Port: 9000},
+				"http":  {Port: 8080},
+			}}}},
+			"database": {Spec: scoretypes.Workload{Service: &scoretypes.WorkloadService{Ports: scoretypes.WorkloadServicePorts{
+				"sql": {Port: 5432, Protocol: &tcp},
+			}}}},
+			"invalid": {Spec: scoretypes.Workload{
+				Metadata: map[string]interface{}{"annotations": map[string]interface{}{"score-aca.score.dev/ingress-port": "unknown"}},
+				Service:  &scoretypes.WorkloadService{Ports: scoretypes.WorkloadServicePorts{"web": {Port: 80}}},
+			}},
+			"worker": {Spec: scoretypes.Workload{}},
+		},
+	})
+	assert.Equal(t, Uri, p.Uri())
+	assert.True(t, p.Match("service.default#frontend.backend"))
+	assert.False(t, p.Match("postgres.default#frontend.db"))
+
+	t.Run("success", func(t *testing.T) {
+		out, err := p.Provision(context.Background(), &provisioners.Input{ResourceParams: map[string]interface{}{"workload": "backend"}})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"host":        "backend-container-app",
+			"port":        80,
+			"target_port": 9898,
+			"ports":       map[string]interface{}{"web": 80},
+			"fqdn":        "backend-container-app.internal.${containerAppEnvironment.properties.defaultDomain}",
+			"url":         "http://backend-container-app",
+		}, out.ResourceOutputs)
+		assert.Equal(t, map[string]interface{}{"workload": "backend"}, out.ResourceState)
+	})
+
+	t.Run("additional port", func(t *testing.T) {
+		out, err := p.Provision(context.Background(), &provisioners.Input{ResourceParams: map[string]interface{}{"workload": "api"}})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"host":        "api-container-app",
+			"port":        80,
+			"target_port": 9000,
+			"ports":       map[string]interface{}{"admin": 80, "http": 8080},
+			"fqdn":        "api-container-app.internal.${containerAppEnvironment.properties.defaultDomain}",
+			"url":         "http://api-container-app",
+		}, out.ResourceOutputs)
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		out, err := p.Provision(context.Background(), &provisioners.Input{ResourceParams: map[string]interface{}{"workload": "database"}})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"host":        "database-container-app",
+			"port":        5432,
+			"target_port": 5432,
+			"ports":       map[string]interface{}{"sql": 5432},
+			"fqdn":        "database-container-app.internal.${containerAppEnvironment.properties.defaultDomain}",
+			"url":         "tcp://database-container-app:5432",
+		}, out.ResourceOutputs)
+	})
+
+	for _, tc := range []struct {
+		name   string
+		params map[string]interface{}
+		err    string
+	}{
+		{name: "missing param", params: nil, err: "missing 'workload' param"},
+		{name: "invalid param", params: map[string]interface{}{"workload": 1}, err: "'workload' param must be a non-empty string"},
+		{name: "unknown workload", params: map[string]interface{}{"workload": "unknown"}, err: "workload 'unknown' does not exist in the project"},
+		{name: "no service", params: map[string]interface{}{"workload": "worker"}, err: "workload 'worker' has no service ports"},
+		{name: "invalid ingress", params: map[string]interface{}{"workload": "invalid"}, err: "workload 'invalid': annotation 'score-aca.score.dev/ingress-port': 'unknown' is not a service port, expected one of web"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := p.Provision(context.Background(), &provisioners.Input{ResourceParams: tc.params})
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
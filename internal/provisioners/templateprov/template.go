@@ -0,0 +1,176 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package templateprov
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"gopkg.in/yaml.v3"
+
+	"github.com/score-spec/score-aca/internal/convert"
+	"github.com/score-spec/score-aca/internal/provisioners"
+)
+
+// Scheme is the uri scheme of template provisioners.
+const Scheme = "template"
+
+// Provisioner is a provisioner that renders Go templates to compute the state, shared state, outputs, and Bicep
+// declarations of a resource.
+type Provisioner struct {
+	provisioners.ResourceMatcher `yaml:",inline"`
+
+	// Description is an optional human-readable description of the provisioner.
+	Description string `yaml:"description,omitempty"`
+
+	// InitTemplate is rendered first and decoded as a map that is available as .Init to the other templates.
+	InitTemplate string `yaml:"init,omitempty"`
+	// StateTemplate is decoded as the new resource state, available as .State to later templates.
+	StateTemplate string `yaml:"state,omitempty"`
+	// SharedStateTemplate is decoded as a patch to the shared state, available as .Shared to later templates.
+	SharedStateTemplate string `yaml:"shared,omitempty"`
+	// OutputsTemplate is decoded as the resource outputs that placeholders such as ${resources.db.host} resolve to.
+	OutputsTemplate string `yaml:"outputs,omitempty"`
+	// BicepTemplate is rendered as raw Bicep declarations that are added to the generated manifest.
+	BicepTemplate string `yaml:"bicep,omitempty"`
+	// SecretOutputs lists the outputs that hold sensitive values, such as passwords or Key Vault secret urls.
+	SecretOutputs []string `yaml:"secret_outputs,omitempty"`
+	// RoleAssignmentsTemplate is decoded as a list of roles on the resources declared by the Bicep template, which are
+	// granted to the container apps using the resource.
+	RoleAssignmentsTemplate string `yaml:"role_assignments,omitempty"`
+}
+
+// templateFuncs returns the sprig functions plus the Bicep specific helpers.
+func templateFuncs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["bicepSymbol"] = convert.BicepSymbol
+	funcs["bicepString"] = func(value interface{}) string {
+		return convert.BicepString(fmt.Sprint(value))
+	}
+	funcs["bicepInt"] = bicepInt
+	return funcs
+}
+
+// bicepInt returns the value as an integer to place in Bicep unquoted, failing on any value that is not a whole number
+func bicepInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v == math.Trunc(v) {
+			return int(v), nil
+		}
+	case string:
+		if i, err := strconv.Atoi(v); err == nil {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("'%v' is not an integer", value)
+}
+
+func renderTemplate(raw string, data interface{}) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	prepped, err := template.New("").Funcs(templateFuncs()).Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template: %w", err)
+	}
+	buff := new(bytes.Buffer)
+	if err := prepped.Execute(buff, data); err != nil {
+		return "", fmt.Errorf("failed to execute template: %w", err)
+	}
+	return buff.String(), nil
+}
+
+func renderTemplateAndDecode(raw string, data interface{}) (map[string]interface{}, error) {
+	rendered, err := renderTemplate(raw, data)
+	if err != nil {
+		return nil, err
+	} else if strings.TrimSpace(rendered) == "" {
+		return nil, nil
+	}
+	var out map[string]interface{}
+	if err := yaml.Unmarshal([]byte(rendered), &out); err != nil {
+		slog.Debug(fmt.Sprintf("template output was '%s' from template '%s'", rendered, raw))
+		return nil, fmt.Errorf("failed to decode output: %w", err)
+	}
+	return out, nil
+}
+
+// Provision renders the templates in order: init, state, shared, outputs, bicep, and finally role_assignments. Each
+// template can access the results of the previous ones.
+func (p *Provisioner) Provision(ctx context.Context, input *provisioners.Input) (*provisioners.ProvisionOutput, error) {
+	out := &provisioners.ProvisionOutput{}
+
+	data := map[string]interface{}{
+		"Uid":              input.ResourceUid,
+		"Type":             input.ResourceType,
+		"Class":            input.ResourceClass,
+		"Id":               input.ResourceId,
+		"Params":           input.ResourceParams,
+		"Metadata":         input.ResourceMetadata,
+		"SourceWorkload":   input.SourceWorkload,
+		"WorkloadMetadata": input.WorkloadMetadata,
+		"State":            input.ResourceState,
+		"Shared":           input.SharedState,
+	}
+
+	init, err := renderTemplateAndDecode(p.InitTemplate, data)
+	if err != nil {
+		return nil, fmt.Errorf("init template failed: %w", err)
+	}
+	data["Init"] = init
+
+	if out.ResourceState, err = renderTemplateAndDecode(p.StateTemplate, data); err != nil {
+		return nil, fmt.Errorf("state template failed: %w", err)
+	}
+	data["State"] = out.ResourceState
+
+	if out.SharedState, err = renderTemplateAndDecode(p.SharedStateTemplate, data); err != nil {
+		return nil, fmt.Errorf("shared template failed: %w", err)
+	}
+	data["Shared"] = provisioners.PatchSharedState(input.SharedState, out.SharedState)
+
+	if out.ResourceOutputs, err = renderTemplateAndDecode(p.OutputsTemplate, data); err != nil {
+		return nil, fmt.Errorf("outputs template failed: %w", err)
+	}
+	data["Outputs"] = out.ResourceOutputs
+
+	if out.Bicep, err = renderTemplate(p.BicepTemplate, data); err != nil {
+		return nil, fmt.Errorf("bicep template failed: %w", err)
+	}
+	out.SecretOutputs = p.SecretOutputs
+
+	rawRoleAssignments, err := renderTemplate(p.RoleAssignmentsTemplate, data)
+	if err != nil {
+		return nil, fmt.Errorf("role_assignments template failed: %w", err)
+	} else if err := yaml.Unmarshal([]byte(rawRoleAssignments), &out.RoleAssignments); err != nil {
+		return nil, fmt.Errorf("role_assignments template failed: failed to decode output: %w", err)
+	}
+
+	return out, nil
+}
+
+var _ provisioners.Provisioner = (*Provisioner)(nil)
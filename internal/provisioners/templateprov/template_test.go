@@ -0,0 +1,152 @@
+// Copyright 2024 Humanitec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package templateprov
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/score-spec/score-aca/internal/provisioners"
+	"github.com/score-spec/score-aca/internal/state"
+)
+
+func TestProvision_nominal(t *testing.T) {
+	p := &Provisioner{
+		ResourceMatcher: provisioners.ResourceMatcher{ProvisionerUri: "template://example", ResType: "thing"},
+		InitTemplate:    `symbol: {{ bicepSymbol "thing" .Id }}`,
+		StateTemplate: `
+counter: {{ add (dig "counter" 0 .State) 1 }}
+`,
+		SharedStateTemplate: `
+last: {{ .Uid }}
+`,
+		OutputsTemplate: `
+host: {{ printf "${%s.properties.host}" .Init.symbol | quote }}
+workload: {{ .WorkloadMetadata.name }}
+class: {{ .Class }}
+counter: {{ .State.counter }}
+last: {{ .Shared.last }}
+param: {{ .Params.size }}
+`,
+		BicepTemplate: `resource {{ .Init.symbol }} 'Example.Thing@2024-01-01' = {
+  name: '{{ .SourceWorkload }}-{{ .Params.size }}'
+}`,
+		SecretOutputs: []string{"host"},
+		RoleAssignmentsTemplate: `
+- scope: {{ .Init.symbol }}
+  role: {{ .Params.role }}
+`,
+	}
+
+	out, err := p.Provision(context.Background(), &provisioners.Input{
+		ResourceUid:      "thing.default#example.my-thing",
+		ResourceType:     "thing",
+		ResourceClass:    "default",
+		ResourceId:       "example.my-thing",
+		ResourceParams:   map[string]interface{}{"size": "large", "role": "2a2b9908-6ea1-4ae2-8e65-a410df84e7d1"},
+		SourceWorkload:   "example",
+		WorkloadMetadata: map[string]interface{}{"name": "example"},
+		ResourceState:    map[string]interface{}{"counter": 1},
+		SharedState:      map[string]interface{}{},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"counter": 2}, out.ResourceState)
+	assert.Equal(t, map[string]interface{}{"last": "thing.default#example.my-thing"}, out.SharedState)
+	assert.Equal(t, map[string]interface{}{
+		"host":     "${thing_example_my_thing.properties.host}",
+		"workload": "example",
+		"class":    "default",
+		"counter":  2,
+		"last":     "thing.default#example.my-thing",
+		"param":    "large",
+	}, out.ResourceOutputs)
+	assert.Equal(t, `resource thing_example_my_thing 'Example.Thing@2024-01-01' = {
+  name: 'example-large'
+}`, out.Bicep)
+	assert.Equal(t, []string{"host"}, out.SecretOutputs)
+	assert.Equal(t, []state.RoleAssignment{{Scope: "thing_example_my_thing", Role: "2a2b9908-6ea1-4ae2-8e65-a410df84e7d1"}}, out.RoleAssignments)
+}
+
+func TestProvision_bicep_string(t *testing.T) {
+	p := &Provisioner{
+		ResourceMatcher: provisioners.ResourceMatcher{ProvisionerUri: "template://example", ResType: "thing"},
+		BicepTemplate: `resource thing 'Example.Thing@2024-01-01' = {
+  name: {{ .Params.name | bicepString }}
+  size: {{ .Params.size | bicepString }}
+  count: {{ .Params.size | bicepInt }}
+  replicas: {{ .Params.replicas | bicepInt }}
+}`,
+	}
+	out, err := p.Provision(context.Background(), &provisioners.Input{
+		ResourceUid:    "thing.default#example.my-thing",
+		ResourceParams: map[string]interface{}{"name": "it's ${x}\\", "size": 3, "replicas": "2"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `resource thing 'Example.Thing@2024-01-01' = {
+  name: 'it\'s \${x}\\'
+  size: '3'
+  count: 3
+  replicas: 2
+}`, out.Bicep)
+}
+
+func TestProvision_empty(t *testing.T) {
+	p := &Provisioner{ResourceMatcher: provisioners.ResourceMatcher{ProvisionerUri: "template://example", ResType: "thing"}}
+	out, err := p.Provision(context.Background(), &provisioners.Input{})
+	require.NoError(t, err)
+	assert.Equal(t, &provisioners.ProvisionOutput{}, out)
+}
+
+func TestProvision_errors(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		p        *Provisioner
+		expected string
+	}{
+		{
+			name:     "bad syntax",
+			p:        &Provisioner{StateTemplate: `{{ .Unclosed`},
+			expected: "state template failed: failed to parse template: template: :1: unclosed action",
+		},
+		{
+			name:     "not a map",
+			p:        &Provisioner{OutputsTemplate: `- a`},
+			expected: "outputs template failed: failed to decode output: yaml: unmarshal errors:\n  line 1: cannot unmarshal !!seq into map[string]interface {}",
+		},
+		{
+			name:     "role assignments not a list",
+			p:        &Provisioner{RoleAssignmentsTemplate: `scope: a`},
+			expected: "role_assignments template failed: failed to decode output: yaml: unmarshal errors:\n  line 1: cannot unmarshal !!map into []state.RoleAssignment",
+		},
+		{
+			name:     "execution failure",
+			p:        &Provisioner{BicepTemplate: `{{ fail "boom" }}`},
+			expected: "bicep template failed: failed to execute template: template: :1:3: executing \"\" at <fail \"boom\">: error calling fail: boom",
+		},
+		{
+			name:     "not an integer",
+			p:        &Provisioner{BicepTemplate: `{{ bicepInt "1}" }}`},
+			expected: "bicep template failed: failed to execute template: template: :1:3: executing \"\" at <bicepInt \"1}\">: error calling bicepInt: '1}' is not an integer",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.p.Provision(context.Background(), &provisioners.Input{})
+			assert.EqualError(t, err, tc.expected)
+		})
+	}
+}
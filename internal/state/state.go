@@ -32,7 +32,23 @@ const (
 
 type WorkloadExtras struct{}
 
-type ResourceExtras struct{}
+type ResourceExtras struct {
+	// Bicep holds the Bicep declarations emitted by the provisioner of the resource.
+	Bicep string `yaml:"bicep,omitempty"`
+	// SecretOutputs lists the outputs of the resource that hold sensitive values.
+	SecretOutputs []string `yaml:"secret_outputs,omitempty"`
+	// RoleAssignments lists the roles granted to the container apps using the resource.
+	RoleAssignments []RoleAssignment `yaml:"role_assignments,omitempty"`
+}
+
+// RoleAssignment grants a role on a resource declared in the Bicep of a provisioner to the system-assigned identity of
+// each container app using the resource.
+type RoleAssignment struct {
+	// Scope is the symbolic name of the resource that the role is granted on.
+	Scope string `yaml:"scope" json:"scope"`
+	// Role is the id of the role definition, such as 2a2b9908-6ea1-4ae2-8e65-a410df84e7d1 for Storage Blob Data Reader.
+	Role string `yaml:"role" json:"role"`
+}
 
 type State = framework.State[framework.NoExtras, WorkloadExtras, ResourceExtras]
 